@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// bodyContext passes record level and output functions to body decoders
+type bodyContext struct {
+	showText showFn
+	showData showFn
+	level    logLevel
+	depth    int
+}
+
+// bodyDecodeFn decodes body and shows decoded representation with record level
+type bodyDecodeFn func(c *bodyContext, name, body string, params map[string]string) error
+
+// bodyDecoder is selected by Content-Type media type or by sniffing body
+type bodyDecoder struct {
+	name       string
+	mediaTypes []string
+	sniff      func(body string) bool
+	decode     bodyDecodeFn
+}
+
+// multipartPart represents decoded part of multipart body
+type multipartPart struct {
+	Name     string              `json:"name,omitempty"`
+	Filename string              `json:"filename,omitempty"`
+	Headers  map[string][]string `json:"headers,omitempty"`
+	Body     string              `json:"body,omitempty"`
+}
+
+// maxNestedBodyDepth limits recursion of base64 and multipart decoders
+const maxNestedBodyDepth = 4
+
+// maxHexDumpBytes limits binary data shown by hex dumps
+const maxHexDumpBytes = 4 << 10
+
+// bodyDecoders are checked in order, first media type match wins, then first sniff match
+var bodyDecoders []*bodyDecoder
+
+var formRegexp = regexp.MustCompile(`^[\w.\-\[\]%]+=[^&\s]*(&[\w.\-\[\]%]+=[^&\s]*)*$`)
+
+var base64Regexp = regexp.MustCompile(`^[A-Za-z0-9+/\r\n]+={0,2}$`)
+
+func init() {
+	registerBodyDecoder(&bodyDecoder{
+		name:       "xml",
+		mediaTypes: []string{"application/xml", "text/xml", "application/soap+xml", "+xml"},
+		sniff:      sniffXMLBody,
+		decode:     decodeXMLBody,
+	})
+	registerBodyDecoder(&bodyDecoder{
+		name:       "json",
+		mediaTypes: []string{"application/json", "text/json", "+json"},
+		sniff:      sniffJSONBody,
+		decode:     decodeJSONBody,
+	})
+	registerBodyDecoder(&bodyDecoder{
+		name:       "multipart",
+		mediaTypes: []string{"multipart/"},
+		sniff:      sniffMultipartBody,
+		decode:     decodeMultipartBody,
+	})
+	registerBodyDecoder(&bodyDecoder{
+		name:       "base64",
+		mediaTypes: []string{"application/base64"},
+		sniff:      sniffBase64Body,
+		decode:     decodeBase64Body,
+	})
+	registerBodyDecoder(&bodyDecoder{
+		name:       "form",
+		mediaTypes: []string{"application/x-www-form-urlencoded"},
+		sniff:      formRegexp.MatchString,
+		decode:     decodeFormBody,
+	})
+	registerBodyDecoder(&bodyDecoder{
+		name:       "hex",
+		mediaTypes: []string{"application/protobuf", "application/x-protobuf", "application/grpc", "application/octet-stream"},
+		decode:     decodeHexBody,
+	})
+}
+
+// registerBodyDecoder adds decoder to the registry
+func registerBodyDecoder(d *bodyDecoder) {
+	bodyDecoders = append(bodyDecoders, d)
+}
+
+// findBodyDecoder selects decoder by media type, then by sniffing body
+func findBodyDecoder(mediaType, body string) *bodyDecoder {
+	if mediaType != "" {
+		for _, d := range bodyDecoders {
+			for _, t := range d.mediaTypes {
+				if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) ||
+					(strings.HasPrefix(t, "+") && strings.HasSuffix(mediaType, t)) {
+					return d
+				}
+			}
+		}
+	}
+	for _, d := range bodyDecoders {
+		if d.sniff != nil && d.sniff(body) {
+			return d
+		}
+	}
+	return nil
+}
+
+// bodyDecoderByName returns registered decoder, nil if not found
+func bodyDecoderByName(name string) *bodyDecoder {
+	for _, d := range bodyDecoders {
+		if d.name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+// headerValue returns first value of header from decoded `headers` field,
+// exact name is preferred, then other case variants in sorted order
+func headerValue(headers interface{}, name string) string {
+	m, ok := headers.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	if v, ok := m[name]; ok {
+		return firstHeaderValue(v)
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		if strings.EqualFold(k, name) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+	return firstHeaderValue(m[keys[0]])
+}
+
+func firstHeaderValue(v interface{}) string {
+	switch values := v.(type) {
+	case string:
+		return values
+	case []interface{}:
+		if len(values) > 0 {
+			s, _ := values[0].(string)
+			return s
+		}
+	}
+	return ""
+}
+
+// showBody decodes body field by Content-Type from headers or by sniffing
+func showBody(showText, showData showFn, level logLevel, name string, value interface{}, headers interface{}) {
+	str, ok := value.(string)
+	if !ok || str == "" {
+		return
+	}
+	c := &bodyContext{
+		showText: showText,
+		showData: showData,
+		level:    level,
+	}
+	c.show(name, str, headerValue(headers, "Content-Type"), headerValue(headers, "Content-Transfer-Encoding"))
+}
+
+// show selects decoder for body and shows decoding error if any,
+// base64 Content-Transfer-Encoding selects base64 decoder
+func (c *bodyContext) show(name, body, contentType, transferEncoding string) {
+	if c.depth > maxNestedBodyDepth {
+		return
+	}
+	var mediaType string
+	params := make(map[string]string)
+	if contentType != "" {
+		var err error
+		mediaType, params, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			mediaType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+		}
+	}
+
+	var d *bodyDecoder
+	if strings.EqualFold(strings.TrimSpace(transferEncoding), "base64") && isBase64Body(body) {
+		d = bodyDecoderByName("base64")
+	} else {
+		d = findBodyDecoder(mediaType, body)
+	}
+	if d == nil {
+		return
+	}
+	if err := d.decode(c, name, body, params); err != nil {
+		c.showText(c.level, name+"_"+d.name+"_error", err.Error())
+	}
+}
+
+// nested returns context for decoding of nested bodies
+func (c *bodyContext) nested() *bodyContext {
+	n := *c
+	n.depth++
+	return &n
+}
+
+func sniffXMLBody(body string) bool {
+	return strings.HasPrefix(strings.TrimSpace(body), "<")
+}
+
+func decodeXMLBody(c *bodyContext, name, body string, params map[string]string) error {
+	root, prolog, err := decodeRawXML(body)
+	if err != nil {
+		return errors.Wrap(err, "invalid body string xml")
+	}
+	n := root.xmlNode()
+	c.showData(c.level, name+"_xml_json", n)
+	c.showText(c.level, name+"_xml", renderRawXML(root, prolog, xmlRenderOptions.mode, xmlRenderOptions.maxDepth))
+	showCLIXMLStderr(c.showText, c.showData, c.level, name, n)
+	showPowerShellScript(c.showText, c.level, name, n)
+	return nil
+}
+
+// showCLIXMLStderr shows decoded PowerShell CLIXML stderr of winrm soap response
+func showCLIXMLStderr(showText, showData showFn, level logLevel, name string, n *Node) {
+	streams, err := nodeResponseStreams(n)
+	if err != nil || len(streams) == 0 {
+		return
+	}
+	_, stderr := decodeStderr(streams)
+	if stderr == nil {
+		return
+	}
+	if stderr.Error != "" {
+		showText(level, name+"_stderr", stderr.Error)
+	}
+	if len(stderr.ErrorRecords) > 0 {
+		showData(level, name+"_stderr_errors", stderr.ErrorRecords)
+	}
+	if len(stderr.Progress) > 0 {
+		showData(level, name+"_stderr_progress", stderr.Progress)
+	}
+	for _, s := range []struct{ stream, text string }{
+		{"warning", stderr.Warning},
+		{"verbose", stderr.Verbose},
+		{"debug", stderr.Debug},
+		{"information", stderr.Information},
+	} {
+		if s.text != "" {
+			showText(level, name+"_stderr_"+s.stream, s.text)
+		}
+	}
+}
+
+// showPowerShellScript shows decoded script of winrm powershell command request
+func showPowerShellScript(show showFn, level logLevel, name string, n *Node) {
+	command := nodeRequestCommand(n)
+	if !isPowerShellCommand(command) {
+		return
+	}
+	script, err := decodePowerShell(command)
+	if err != nil {
+		return
+	}
+	show(level, name+"_script", script)
+}
+
+func sniffJSONBody(body string) bool {
+	trimmed := strings.TrimSpace(body)
+	return (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed))
+}
+
+func decodeJSONBody(c *bodyContext, name, body string, params map[string]string) error {
+	d := json.NewDecoder(strings.NewReader(body))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return errors.Wrap(err, "invalid body string json")
+	}
+	c.showData(c.level, name+"_json", v)
+	return nil
+}
+
+func decodeFormBody(c *bodyContext, name, body string, params map[string]string) error {
+	values, err := url.ParseQuery(strings.TrimSpace(body))
+	if err != nil {
+		return errors.Wrap(err, "invalid body string form")
+	}
+	form := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		if len(v) == 1 {
+			form[k] = v[0]
+		} else {
+			form[k] = v
+		}
+	}
+	c.showData(c.level, name+"_form", form)
+	return nil
+}
+
+func sniffMultipartBody(body string) bool {
+	return strings.HasPrefix(body, "--") && multipartBoundary(body) != ""
+}
+
+// multipartBoundary takes boundary from the first line of body
+func multipartBoundary(body string) string {
+	i := strings.IndexAny(body, "\r\n")
+	if i < 3 {
+		return ""
+	}
+	return body[2:i]
+}
+
+func decodeMultipartBody(c *bodyContext, name, body string, params map[string]string) error {
+	boundary := params["boundary"]
+	if boundary == "" {
+		boundary = multipartBoundary(body)
+	}
+	if boundary == "" {
+		return errors.Errorf("not found multipart boundary")
+	}
+	r := multipart.NewReader(strings.NewReader(body), boundary)
+	var parts []multipartPart
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.showData(c.level, name+"_multipart", parts)
+			return errors.Wrap(err, "NextPart failed")
+		}
+		data, err := ioutil.ReadAll(p)
+		if err != nil {
+			return errors.Wrap(err, "ReadAll part failed")
+		}
+		part := multipartPart{
+			Name:     p.FormName(),
+			Filename: p.FileName(),
+			Headers:  p.Header,
+		}
+		transferEncoding := p.Header.Get("Content-Transfer-Encoding")
+		if utf8.Valid(data) {
+			part.Body = string(data)
+		} else {
+			part.Body = base64.StdEncoding.EncodeToString(data)
+			transferEncoding = "base64"
+		}
+		parts = append(parts, part)
+		c.nested().show(fmt.Sprintf("%s_part%d", name, len(parts)), part.Body, p.Header.Get("Content-Type"), transferEncoding)
+	}
+	c.showData(c.level, name+"_multipart", parts)
+	return nil
+}
+
+// isBase64Body checks that body is valid standard base64 of at least 16 characters
+func isBase64Body(body string) bool {
+	trimmed := strings.TrimSpace(body)
+	if len(trimmed) < 16 || !base64Regexp.MatchString(trimmed) {
+		return false
+	}
+	_, err := base64.StdEncoding.DecodeString(removeNewLines(trimmed))
+	return err == nil
+}
+
+// sniffBase64Body detects base64 body without Content-Type or Content-Transfer-Encoding hint.
+// Hex ids and tokens are valid base64 too, so body must contain +, / or = padding,
+// or decode to printable text: such ids decode to random bytes
+func sniffBase64Body(body string) bool {
+	if !isBase64Body(body) {
+		return false
+	}
+	trimmed := strings.TrimSpace(body)
+	if strings.ContainsAny(trimmed, "+/=") {
+		return true
+	}
+	data, _ := base64.StdEncoding.DecodeString(removeNewLines(trimmed))
+	return isPrintableText(data)
+}
+
+// isPrintableText checks that data is UTF-8 text without control characters other than whitespace
+func isPrintableText(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) && r != '\t' && r != '\r' && r != '\n' {
+			return false
+		}
+	}
+	return true
+}
+
+func removeNewLines(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+func decodeBase64Body(c *bodyContext, name, body string, params map[string]string) error {
+	data, err := base64.StdEncoding.DecodeString(removeNewLines(strings.TrimSpace(body)))
+	if err != nil {
+		return errors.Wrap(err, "invalid body string base64")
+	}
+	if utf8.Valid(data) && !bytes.ContainsRune(data, 0) {
+		c.showText(c.level, name+"_base64", string(data))
+		c.nested().show(name+"_base64", string(data), "", "")
+		return nil
+	}
+	c.showText(c.level, name+"_base64_hex", hexDump(data))
+	return nil
+}
+
+// decodeHexBody dumps binary body, base64 encoded bodies are decoded first
+func decodeHexBody(c *bodyContext, name, body string, params map[string]string) error {
+	data := []byte(body)
+	if isBase64Body(body) {
+		data, _ = base64.StdEncoding.DecodeString(removeNewLines(strings.TrimSpace(body)))
+	}
+	c.showText(c.level, name+"_hex", hexDump(data))
+	return nil
+}
+
+// hexDump dumps first maxHexDumpBytes of data, noting size of the rest
+func hexDump(data []byte) string {
+	if len(data) <= maxHexDumpBytes {
+		return strings.TrimRight(hex.Dump(data), "\n")
+	}
+	return hex.Dump(data[:maxHexDumpBytes]) + fmt.Sprintf("… %d bytes more", len(data)-maxHexDumpBytes)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestSniffBase64Body(t *testing.T) {
+	tests := []struct {
+		body string
+		want bool
+	}{
+		{"4f2a9c0e7b1d4e3f8a6b5c4d3e2f1a0b", false},
+		{"abcdefABCDEF0123456789abcdefABCD", false},
+		{"0123456789abcdef", false},
+		{base64.StdEncoding.EncodeToString([]byte("hello base64 world")), true},
+		{base64.StdEncoding.EncodeToString([]byte("hello base64 world!")), true},
+		{base64.StdEncoding.EncodeToString([]byte{0xfb, 0xff, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a}), true},
+		{"aGVsbG8=", false},
+		{"not base64 at all, spaces", false},
+	}
+	for _, tt := range tests {
+		if got := sniffBase64Body(tt.body); got != tt.want {
+			t.Errorf("sniffBase64Body(%q) = %t", tt.body, got)
+		}
+	}
+}
+
+func TestHeaderValue(t *testing.T) {
+	headers := map[string]interface{}{
+		"content-type": []interface{}{"text/plain"},
+		"Content-Type": []interface{}{"application/json"},
+		"CONTENT-TYPE": "text/xml",
+		"Accept":       "*/*",
+	}
+	for i := 0; i < 20; i++ {
+		if got := headerValue(headers, "Content-Type"); got != "application/json" {
+			t.Fatalf("exact header name not preferred: %q", got)
+		}
+		if got := headerValue(headers, "content-TYPE"); got != "text/xml" {
+			t.Fatalf("case variants are not sorted: %q", got)
+		}
+	}
+	if got := headerValue(headers, "accept"); got != "*/*" {
+		t.Errorf("accept = %q", got)
+	}
+	if got := headerValue(headers, "X-Missing"); got != "" {
+		t.Errorf("missing header = %q", got)
+	}
+	if got := headerValue(nil, "Accept"); got != "" {
+		t.Errorf("nil headers = %q", got)
+	}
+}
+
+func TestShowBodyTransferEncoding(t *testing.T) {
+	shown := make(map[string]string)
+	show := func(level logLevel, name string, value interface{}) {
+		s, _ := value.(string)
+		shown[name] = s
+	}
+	body := "0123456789abcdef0123456789abcdef"
+	showBody(show, show, logLevelInfo, "body", body, nil)
+	if len(shown) != 0 {
+		t.Errorf("hex id is decoded without hint: %v", shown)
+	}
+	headers := map[string]interface{}{"Content-Transfer-Encoding": []interface{}{"base64"}}
+	showBody(show, show, logLevelInfo, "body", body, headers)
+	if _, ok := shown["body_base64_hex"]; !ok {
+		t.Errorf("base64 transfer encoding is not decoded: %v", shown)
+	}
+}
+
+func decodeTestXMLBody(t *testing.T, body string) (map[string]interface{}, error) {
+	shown := make(map[string]interface{})
+	show := func(level logLevel, name string, value interface{}) {
+		shown[name] = value
+	}
+	err := decodeXMLBody(&bodyContext{showText: show, showData: show, level: logLevelInfo}, "body", body, nil)
+	return shown, err
+}
+
+func TestDecodeXMLBody(t *testing.T) {
+	stderr := base64.StdEncoding.EncodeToString([]byte(testCLIXML))
+	response := `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell">` +
+		`<s:Body><rsp:ReceiveResponse><rsp:Stream Name="stderr" CommandId="c1" End="true">` + stderr + `</rsp:Stream></rsp:ReceiveResponse></s:Body></s:Envelope>`
+	shown, err := decodeTestXMLBody(t, response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, ok := shown["body_xml_json"].(*Node)
+	if !ok {
+		t.Fatalf("xml json is not shown: %v", shown)
+	}
+	want := xml.Name{Space: "http://schemas.microsoft.com/wbem/wsman/1/windows/shell", Local: "ReceiveResponse"}
+	if got := n.Nodes[0].Nodes[0].XMLName; got != want {
+		t.Errorf("namespace is not resolved: %+v", got)
+	}
+	if rendered, _ := shown["body_xml"].(string); !strings.Contains(rendered, "<rsp:Stream Name=\"stderr\"") {
+		t.Errorf("rendered xml:\n%s", rendered)
+	}
+	if got, _ := shown["body_stderr_warning"].(string); got != "disk \"C\" is low" {
+		t.Errorf("stderr warning %q", got)
+	}
+
+	script := base64.StdEncoding.EncodeToString([]byte("G\x00e\x00t\x00-\x00D\x00a\x00t\x00e\x00"))
+	request := `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body><rsp:CommandLine xmlns:rsp="x">` +
+		`<rsp:Command>powershell -EncodedCommand ` + script + `</rsp:Command></rsp:CommandLine></s:Body></s:Envelope>`
+	if shown, err = decodeTestXMLBody(t, request); err != nil || shown["body_script"] != "Get-Date" {
+		t.Errorf("script is not shown: %v %v", err, shown)
+	}
+
+	if _, err := decodeTestXMLBody(t, `<a><b></a>`); err == nil || !strings.Contains(err.Error(), "closed by") {
+		t.Errorf("mismatched end element: %v", err)
+	}
+}
+
+func TestHexDump(t *testing.T) {
+	if got := hexDump([]byte("ab")); got != "00000000  61 62                                             |ab|" {
+		t.Errorf("short dump %q", got)
+	}
+	data := make([]byte, maxHexDumpBytes+100)
+	dump := hexDump(data)
+	lines := strings.Split(dump, "\n")
+	if len(lines) != maxHexDumpBytes/16+1 || lines[len(lines)-1] != "… 100 bytes more" {
+		t.Errorf("long dump has %d lines, last %q", len(lines), lines[len(lines)-1])
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+// clixmlPrefix marks PowerShell serialized streams written to stderr
+const clixmlPrefix = "#< CLIXML"
+
+// clixmlNoiseActivities are progress activities emitted by powershell.exe itself
+var clixmlNoiseActivities = map[string]struct{}{
+	"Preparing modules for first use.": {},
+}
+
+var clixmlEscapeRegexp = regexp.MustCompile("_x([0-9A-Fa-f]{4})_")
+
+var clixmlCategoryInfoRegexp = regexp.MustCompile(`^(\w+): \((.*)\) \[(.*?)\], (.*)$`)
+
+// clixmlStreams contains decoded PowerShell streams from CLIXML document
+type clixmlStreams struct {
+	Error        string              `json:"error,omitempty"`
+	Warning      string              `json:"warning,omitempty"`
+	Verbose      string              `json:"verbose,omitempty"`
+	Debug        string              `json:"debug,omitempty"`
+	Information  string              `json:"information,omitempty"`
+	ErrorRecords []clixmlErrorRecord `json:"error_records,omitempty"`
+	Progress     []clixmlProgress    `json:"progress,omitempty"`
+}
+
+// clixmlErrorRecord is a PowerShell error record with category info
+type clixmlErrorRecord struct {
+	Message               string `json:"message"`
+	Position              string `json:"position,omitempty"`
+	Category              string `json:"category,omitempty"`
+	Activity              string `json:"activity,omitempty"`
+	Reason                string `json:"reason,omitempty"`
+	TargetName            string `json:"target_name,omitempty"`
+	TargetType            string `json:"target_type,omitempty"`
+	FullyQualifiedErrorID string `json:"fully_qualified_error_id,omitempty"`
+}
+
+// clixmlProgress is a PowerShell progress record
+type clixmlProgress struct {
+	Activity          string `json:"activity"`
+	ActivityID        int    `json:"activity_id"`
+	ParentActivityID  int    `json:"parent_activity_id,omitempty"`
+	CurrentOperation  string `json:"current_operation,omitempty"`
+	StatusDescription string `json:"status_description,omitempty"`
+	PercentComplete   int    `json:"percent_complete"`
+	SecondsRemaining  int    `json:"seconds_remaining,omitempty"`
+	Type              string `json:"type"`
+}
+
+func isCLIXML(s string) bool {
+	return strings.HasPrefix(s, clixmlPrefix)
+}
+
+// decodeCLIXML parses `#< CLIXML` document into readable streams
+func decodeCLIXML(s string) (*clixmlStreams, error) {
+	if !isCLIXML(s) {
+		return nil, errors.Errorf("Not found CLIXML prefix")
+	}
+	n, err := decodeXML(strings.TrimSpace(s[len(clixmlPrefix):]))
+	if err != nil {
+		return nil, errors.Wrap(err, "decodeXML failed")
+	}
+
+	texts := make(map[string]*strings.Builder)
+	appendText := func(stream, text string) {
+		b, ok := texts[stream]
+		if !ok {
+			b = &strings.Builder{}
+			texts[stream] = b
+		}
+		b.WriteString(text)
+		if !strings.HasSuffix(text, "\n") {
+			b.WriteString("\n")
+		}
+	}
+
+	var result clixmlStreams
+	for _, child := range n.Nodes {
+		stream := strings.ToLower(nodeAttr(&child, "S"))
+		switch child.XMLName.Local {
+		case "S":
+			appendText(stream, unescapeCLIXML(child.CharData))
+		case "Obj":
+			switch stream {
+			case "progress":
+				if p, ok := parseCLIXMLProgress(&child); ok {
+					result.Progress = append(result.Progress, p)
+				}
+			case "error":
+				result.ErrorRecords = append(result.ErrorRecords, parseCLIXMLErrorObj(&child))
+			default:
+				if toString := nodeChild(&child, "ToString"); toString != nil {
+					appendText(stream, unescapeCLIXML(toString.CharData))
+				}
+			}
+		}
+	}
+
+	result.Error = cleanCLIXMLText(texts["error"])
+	result.Warning = cleanCLIXMLText(texts["warning"])
+	result.Verbose = cleanCLIXMLText(texts["verbose"])
+	result.Debug = cleanCLIXMLText(texts["debug"])
+	result.Information = cleanCLIXMLText(texts["information"])
+	result.ErrorRecords = append(result.ErrorRecords, parseCLIXMLErrorText(result.Error)...)
+	result.Progress = filterCLIXMLProgress(result.Progress)
+	return &result, nil
+}
+
+// unescapeCLIXML replaces _xHHHH_ escapes with UTF-16 code units they encode
+func unescapeCLIXML(s string) string {
+	if !strings.Contains(s, "_x") {
+		return s
+	}
+	var b strings.Builder
+	var units []uint16
+	flush := func() {
+		if len(units) > 0 {
+			b.WriteString(string(utf16.Decode(units)))
+			units = units[:0]
+		}
+	}
+	last := 0
+	for _, m := range clixmlEscapeRegexp.FindAllStringSubmatchIndex(s, -1) {
+		if m[0] != last {
+			flush()
+			b.WriteString(s[last:m[0]])
+		}
+		code, _ := strconv.ParseUint(s[m[2]:m[3]], 16, 16)
+		units = append(units, uint16(code))
+		last = m[1]
+	}
+	flush()
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+func cleanCLIXMLText(b *strings.Builder) string {
+	if b == nil {
+		return ""
+	}
+	s := strings.Replace(b.String(), "\r\n", "\n", -1)
+	return strings.TrimRight(s, " \t\r\n")
+}
+
+// parseCLIXMLErrorText splits formatted error stream into error records
+func parseCLIXMLErrorText(text string) []clixmlErrorRecord {
+	var result []clixmlErrorRecord
+	var current *clixmlErrorRecord
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			current = nil
+		case strings.HasPrefix(trimmed, "+ CategoryInfo") && current != nil:
+			parseCLIXMLCategoryInfo(current, clixmlPropertyValue(trimmed))
+		case strings.HasPrefix(trimmed, "+ FullyQualifiedErrorId") && current != nil:
+			current.FullyQualifiedErrorID = clixmlPropertyValue(trimmed)
+		case strings.HasPrefix(trimmed, "At ") && current != nil && current.Position == "":
+			current.Position = trimmed
+		case strings.HasPrefix(trimmed, "+") && current != nil:
+			// source line and position marker
+		case current == nil:
+			result = append(result, clixmlErrorRecord{Message: trimmed})
+			current = &result[len(result)-1]
+		case current.Position == "" && current.Category == "":
+			current.Message = current.Message + "\n" + trimmed
+		}
+	}
+	return result
+}
+
+func clixmlPropertyValue(line string) string {
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimSpace(line[i+1:])
+}
+
+// parseCLIXMLCategoryInfo parses "Category: (TargetName:TargetType) [Activity], Reason"
+func parseCLIXMLCategoryInfo(r *clixmlErrorRecord, info string) {
+	ss := clixmlCategoryInfoRegexp.FindStringSubmatch(info)
+	if len(ss) < 5 {
+		r.Category = info
+		return
+	}
+	r.Category = ss[1]
+	target := ss[2]
+	if i := strings.LastIndex(target, ":"); i >= 0 {
+		r.TargetName = target[:i]
+		r.TargetType = target[i+1:]
+	} else {
+		r.TargetName = target
+	}
+	r.Activity = ss[3]
+	r.Reason = ss[4]
+}
+
+// parseCLIXMLErrorObj parses serialized ErrorRecord object
+func parseCLIXMLErrorObj(obj *Node) clixmlErrorRecord {
+	var r clixmlErrorRecord
+	if toString := nodeChild(obj, "ToString"); toString != nil {
+		r.Message = unescapeCLIXML(toString.CharData)
+	}
+	for _, props := range obj.Nodes {
+		if props.XMLName.Local != "MS" && props.XMLName.Local != "Props" {
+			continue
+		}
+		for _, p := range props.Nodes {
+			value := unescapeCLIXML(p.CharData)
+			switch nodeAttr(&p, "N") {
+			case "ErrorCategory_Category":
+				if r.Category == "" {
+					r.Category = value
+				}
+			case "ErrorCategory_Reason":
+				r.Reason = value
+			case "ErrorCategory_Activity":
+				r.Activity = value
+			case "ErrorCategory_TargetName":
+				r.TargetName = value
+			case "ErrorCategory_TargetType":
+				r.TargetType = value
+			case "ErrorCategory_Message":
+				parseCLIXMLCategoryInfo(&r, value)
+			case "FullyQualifiedErrorId":
+				r.FullyQualifiedErrorID = value
+			}
+		}
+	}
+	return r
+}
+
+// parseCLIXMLProgress parses <Obj S="progress"> with nested <PR N="Record">
+func parseCLIXMLProgress(obj *Node) (clixmlProgress, bool) {
+	ms := nodeChild(obj, "MS")
+	if ms == nil {
+		return clixmlProgress{}, false
+	}
+	pr := nodeChild(ms, "PR")
+	if pr == nil {
+		return clixmlProgress{}, false
+	}
+	var p clixmlProgress
+	for _, field := range pr.Nodes {
+		value := strings.TrimSpace(unescapeCLIXML(field.CharData))
+		switch field.XMLName.Local {
+		case "AV":
+			p.Activity = value
+		case "AI":
+			p.ActivityID, _ = strconv.Atoi(value)
+		case "S":
+			p.CurrentOperation = value
+		case "PI":
+			p.ParentActivityID, _ = strconv.Atoi(value)
+		case "PC":
+			p.PercentComplete, _ = strconv.Atoi(value)
+		case "T":
+			p.Type = value
+		case "SR":
+			p.SecondsRemaining, _ = strconv.Atoi(value)
+		case "SD":
+			p.StatusDescription = value
+		}
+	}
+	return p, true
+}
+
+// filterCLIXMLProgress removes powershell.exe noise and repeated records
+func filterCLIXMLProgress(progress []clixmlProgress) []clixmlProgress {
+	var result []clixmlProgress
+	for _, p := range progress {
+		if _, noise := clixmlNoiseActivities[p.Activity]; noise {
+			continue
+		}
+		if len(result) > 0 && result[len(result)-1] == p {
+			continue
+		}
+		result = append(result, p)
+	}
+	return result
+}
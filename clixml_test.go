@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+const testCLIXML = `#< CLIXML
+<Objs Version="1.1.0.1" xmlns="http://schemas.microsoft.com/powershell/2004/04">` +
+	`<Obj S="progress" RefId="0"><TN RefId="0"><T>System.Management.Automation.PSCustomObject</T><T>System.Object</T></TN><MS><I64 N="SourceId">1</I64><PR N="Record"><AV>Preparing modules for first use.</AV><AI>0</AI><Nil /><PI>-1</PI><PC>-1</PC><T>Completed</T><SR>-1</SR><SD> </SD></PR></MS></Obj>` +
+	`<Obj S="progress" RefId="1"><TNRef RefId="0" /><MS><I64 N="SourceId">2</I64><PR N="Record"><AV>Copy</AV><AI>1</AI><Nil /><PI>-1</PI><PC>50</PC><T>Processing</T><SR>10</SR><SD>half</SD></PR></MS></Obj>` +
+	`<Obj S="progress" RefId="2"><TNRef RefId="0" /><MS><I64 N="SourceId">2</I64><PR N="Record"><AV>Copy</AV><AI>1</AI><Nil /><PI>-1</PI><PC>50</PC><T>Processing</T><SR>10</SR><SD>half</SD></PR></MS></Obj>` +
+	`<S S="Error">Get-Item : Cannot find path 'C:\x' because it does not exist._x000D__x000A_</S>` +
+	`<S S="Error">At line:1 char:1_x000D__x000A_</S>` +
+	`<S S="Error">+ Get-Item C:\x_x000D__x000A_</S>` +
+	`<S S="Error">+ ~~~~~~~~~~~~~_x000D__x000A_</S>` +
+	`<S S="Error">    + CategoryInfo          : ObjectNotFound: (C:\x:String) [Get-Item], ItemNotFoundException_x000D__x000A_</S>` +
+	`<S S="Error">    + FullyQualifiedErrorId : PathNotFound,Microsoft.PowerShell.Commands.GetItemCommand_x000D__x000A_</S>` +
+	`<S S="Error"> _x000D__x000A_</S>` +
+	`<S S="warning">disk _x0022_C_x0022_ is low_x000D__x000A_</S>` +
+	`<S S="verbose">step 1_x000A_</S>` +
+	`</Objs>`
+
+func TestDecodeCLIXML(t *testing.T) {
+	got, err := decodeCLIXML(testCLIXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &clixmlStreams{
+		Error: "Get-Item : Cannot find path 'C:\\x' because it does not exist.\n" +
+			"At line:1 char:1\n" +
+			"+ Get-Item C:\\x\n" +
+			"+ ~~~~~~~~~~~~~\n" +
+			"    + CategoryInfo          : ObjectNotFound: (C:\\x:String) [Get-Item], ItemNotFoundException\n" +
+			"    + FullyQualifiedErrorId : PathNotFound,Microsoft.PowerShell.Commands.GetItemCommand",
+		Warning: `disk "C" is low`,
+		Verbose: "step 1",
+		ErrorRecords: []clixmlErrorRecord{{
+			Message:               "Get-Item : Cannot find path 'C:\\x' because it does not exist.",
+			Position:              "At line:1 char:1",
+			Category:              "ObjectNotFound",
+			Activity:              "Get-Item",
+			Reason:                "ItemNotFoundException",
+			TargetName:            "C:\\x",
+			TargetType:            "String",
+			FullyQualifiedErrorID: "PathNotFound,Microsoft.PowerShell.Commands.GetItemCommand",
+		}},
+		Progress: []clixmlProgress{{
+			Activity:          "Copy",
+			ActivityID:        1,
+			ParentActivityID:  -1,
+			StatusDescription: "half",
+			PercentComplete:   50,
+			SecondsRemaining:  10,
+			Type:              "Processing",
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%#v\nwant\n%#v", got, want)
+	}
+}
+
+func TestDecodeCLIXMLErrors(t *testing.T) {
+	for _, s := range []string{"", "plain stderr", "#< CLIXML\n<Objs>", "#< CLIXML"} {
+		if _, err := decodeCLIXML(s); err == nil {
+			t.Errorf("%q: error expected", s)
+		}
+	}
+}
+
+func TestParseCLIXMLErrorObj(t *testing.T) {
+	n, err := decodeXML(`<Obj S="error" RefId="0"><TN RefId="0"><T>System.Management.Automation.ErrorRecord</T></TN><ToString>Access is denied</ToString><Props><S N="ErrorCategory_Category">PermissionDenied</S><S N="ErrorCategory_Activity">Remove-Item</S><S N="ErrorCategory_Reason">UnauthorizedAccessException</S><S N="ErrorCategory_TargetName">C:_x005C_x</S><S N="ErrorCategory_TargetType">String</S><S N="FullyQualifiedErrorId">RemoveItemUnauthorizedAccessError</S></Props></Obj>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := clixmlErrorRecord{
+		Message:               "Access is denied",
+		Category:              "PermissionDenied",
+		Activity:              "Remove-Item",
+		Reason:                "UnauthorizedAccessException",
+		TargetName:            `C:\x`,
+		TargetType:            "String",
+		FullyQualifiedErrorID: "RemoveItemUnauthorizedAccessError",
+	}
+	if got := parseCLIXMLErrorObj(n); got != want {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestUnescapeCLIXML(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"a_x000D__x000A_b", "a\r\nb"},
+		{"_x0041_", "A"},
+		{"_xD83D__xDE00_", "\U0001F600"},
+		{"lone _xD83D_ surrogate", "lone \uFFFD surrogate"},
+		{"not_x00_escape", "not_x00_escape"},
+		{"_x00e9_", "é"},
+	}
+	for _, tt := range tests {
+		if got := unescapeCLIXML(tt.in); got != tt.want {
+			t.Errorf("unescapeCLIXML(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeStderrKeepsRawCLIXML(t *testing.T) {
+	streams := []responseStream{
+		{Name: "stdout", Value: base64.StdEncoding.EncodeToString([]byte("out"))},
+		{Name: "stderr", Value: base64.StdEncoding.EncodeToString([]byte(testCLIXML[:40]))},
+		{Name: "stderr", Value: base64.StdEncoding.EncodeToString([]byte(testCLIXML[40:]))},
+	}
+	raw, decoded := decodeStderr(streams)
+	if raw != testCLIXML {
+		t.Errorf("raw stderr %q", raw)
+	}
+	if decoded == nil || decoded.Warning != `disk "C" is low` {
+		t.Errorf("decoded %#v", decoded)
+	}
+
+	plain := []responseStream{{Name: "stderr", Value: base64.StdEncoding.EncodeToString([]byte("error\n"))}}
+	if raw, decoded := decodeStderr(plain); raw != "error\n" || decoded != nil {
+		t.Errorf("plain stderr %q %#v", raw, decoded)
+	}
+}
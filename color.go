@@ -0,0 +1,224 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type colorMode int
+
+const (
+	colorAuto colorMode = iota
+	colorAlways
+	colorNever
+)
+
+// resetStyle resets all terminal text attributes
+const resetStyle = "\u001b[0m"
+
+// defaultThemeSpec colors record lines by level
+const defaultThemeSpec = "level.trace=green,level.debug=green,level.warn=yellow,level.error=red"
+
+// colorOptions is set by -color and -theme flags and used by all stdout formatters
+var colorOptions = struct {
+	enabled    bool
+	theme      *colorTheme
+	highlights []*regexp.Regexp
+}{
+	theme: mustParseTheme(defaultThemeSpec),
+}
+
+// colorTheme contains escape sequences of record line by level, field names, field values by name
+// and syntax elements of values by type
+type colorTheme struct {
+	levels map[logLevel]string
+	key    string
+	fields map[string]string
+	syntax map[string]string
+}
+
+func parseColorMode(mode string) (colorMode, error) {
+	switch mode {
+	case "", "auto":
+		return colorAuto, nil
+	case "always":
+		return colorAlways, nil
+	case "never":
+		return colorNever, nil
+	default:
+		return colorAuto, errors.Errorf("Unknown color mode %s", mode)
+	}
+}
+
+// detectColors decides if stdout is colored: -color flag, then NO_COLOR and FORCE_COLOR
+// environment variables, then terminal detection. Virtual terminal is enabled on windows
+func detectColors(mode colorMode) bool {
+	fd := int(os.Stdout.Fd())
+	switch mode {
+	case colorAlways:
+		enableVirtualTerminal(fd)
+		return true
+	case colorNever:
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	if force, ok := os.LookupEnv("FORCE_COLOR"); ok && force != "0" && force != "false" {
+		enableVirtualTerminal(fd)
+		return true
+	}
+	if !isTerminal(fd) || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return enableVirtualTerminal(fd)
+}
+
+// parseTheme parses comma separated `target=style` list on top of default theme.
+// Targets are level.<level>, key, field.<name>, value.<string|number|bool|null>,
+// json.key, xml.tag, xml.attr and highlight, styles are `+` separated
+// attributes and colors: bold+red, underline+cyan, 208, #ff8800, on-blue.
+// `none` as a list item clears default level colors
+func parseTheme(spec string) (*colorTheme, error) {
+	t := &colorTheme{
+		levels: make(map[logLevel]string),
+		fields: make(map[string]string),
+		syntax: make(map[string]string),
+	}
+	if spec != "none" && !strings.HasPrefix(spec, "none,") {
+		t = mustParseTheme(defaultThemeSpec)
+	}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" || item == "none" {
+			continue
+		}
+		i := strings.Index(item, "=")
+		if i < 0 {
+			return nil, errors.Errorf("invalid theme item %s, expected target=style", item)
+		}
+		target, styleSpec := item[:i], item[i+1:]
+		style, err := parseStyle(styleSpec)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid theme item %s", item)
+		}
+		switch {
+		case target == "key":
+			t.key = style
+		case strings.HasPrefix(target, "level."):
+			name := strings.TrimPrefix(target, "level.")
+			level, ok := logLevelByName(name)
+			if !ok {
+				return nil, errors.Errorf("invalid theme item %s, unknown level %s", item, name)
+			}
+			t.levels[level] = style
+		case strings.HasPrefix(target, "field."):
+			t.fields[strings.TrimPrefix(target, "field.")] = style
+		case isSyntaxTarget(target):
+			t.syntax[target] = style
+		default:
+			return nil, errors.Errorf("invalid theme item %s, unknown target %s", item, target)
+		}
+	}
+	return t, nil
+}
+
+func mustParseTheme(spec string) *colorTheme {
+	t, err := parseTheme("none," + spec)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// logLevelByName is strict version of parseLogLevel
+func logLevelByName(name string) (logLevel, bool) {
+	for level := logLevelTrace; level <= logLevelError; level++ {
+		if logLevelName(level) == name {
+			return level, true
+		}
+	}
+	return logLevelWarn, false
+}
+
+var styleCodes = map[string]string{
+	"bold":      "1",
+	"dim":       "2",
+	"italic":    "3",
+	"underline": "4",
+	"blink":     "5",
+	"reverse":   "7",
+	"black":     "30",
+	"red":       "31",
+	"green":     "32",
+	"yellow":    "33",
+	"blue":      "34",
+	"magenta":   "35",
+	"cyan":      "36",
+	"white":     "37",
+}
+
+// parseStyle converts style like bold+red to escape sequence
+func parseStyle(spec string) (string, error) {
+	var codes []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(spec), func(r rune) bool { return r == '+' || r == ' ' }) {
+		background := strings.HasPrefix(word, "on-")
+		word = strings.TrimPrefix(word, "on-")
+		bright := strings.HasPrefix(word, "bright-")
+		word = strings.TrimPrefix(word, "bright-")
+		code, ok := styleCodes[word]
+		switch {
+		case word == "none":
+			continue
+		case ok && code[0] >= '3':
+			n, _ := strconv.Atoi(code)
+			if bright {
+				n += 60
+			}
+			if background {
+				n += 10
+			}
+			codes = append(codes, strconv.Itoa(n))
+		case ok && !background && !bright:
+			codes = append(codes, code)
+		case strings.HasPrefix(word, "#") && len(word) == 7:
+			rgb, err := strconv.ParseUint(word[1:], 16, 32)
+			if err != nil {
+				return "", errors.Errorf("invalid color %s", word)
+			}
+			prefix := "38;2;"
+			if background {
+				prefix = "48;2;"
+			}
+			codes = append(codes, prefix+strconv.Itoa(int(rgb>>16))+";"+strconv.Itoa(int(rgb>>8&0xff))+";"+strconv.Itoa(int(rgb&0xff)))
+		default:
+			n, err := strconv.Atoi(word)
+			if err != nil || n < 0 || n > 255 {
+				return "", errors.Errorf("unknown style %s", word)
+			}
+			prefix := "38;5;"
+			if background {
+				prefix = "48;5;"
+			}
+			codes = append(codes, prefix+word)
+		}
+	}
+	if len(codes) == 0 {
+		return "", nil
+	}
+	return "\u001b[" + strings.Join(codes, ";") + "m", nil
+}
+
+// level returns escape sequence of record line of level
+func (t *colorTheme) level(level logLevel) string {
+	return t.levels[level]
+}
+
+// plain reports if theme colors only whole lines, without field name and value styles
+func (t *colorTheme) plain() bool {
+	return t.key == "" && len(t.fields) == 0 && len(t.syntax) == 0
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// defaultCompactTemplate is used by `-compact default`
+const defaultCompactTemplate = "{time} {level:5} {caller} {msg} {*}"
+
+// compactEllipsis marks truncated compact line
+const compactEllipsis = "…"
+
+// compactTemplate formats record as single stdout line, e.g. `{time} {level:5} {msg} {*}`.
+// {name:width} pads value to width, {*} expands to remaining fields as key=value
+type compactTemplate struct {
+	parts []compactPart
+	named map[string]struct{}
+}
+
+type compactPart struct {
+	text  string
+	field string
+	width int
+	rest  bool
+}
+
+// compactSegment is a part of rendered compact line, styled after truncation
+type compactSegment struct {
+	text string
+	kind valueKind
+	key  bool
+}
+
+func parseCompactTemplate(s string) (*compactTemplate, error) {
+	if s == "default" {
+		s = defaultCompactTemplate
+	}
+	t := &compactTemplate{named: make(map[string]struct{})}
+	for s != "" {
+		i := strings.IndexByte(s, '{')
+		if i < 0 {
+			t.parts = append(t.parts, compactPart{text: s})
+			break
+		}
+		if i > 0 {
+			t.parts = append(t.parts, compactPart{text: s[:i]})
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return nil, errors.Errorf("unclosed { at %d", i)
+		}
+		spec := s[i+1 : i+end]
+		s = s[i+end+1:]
+		if spec == "*" {
+			t.parts = append(t.parts, compactPart{rest: true})
+			continue
+		}
+		p := compactPart{field: spec}
+		if j := strings.IndexByte(spec, ':'); j >= 0 {
+			width, err := strconv.Atoi(spec[j+1:])
+			if err != nil || width < 0 {
+				return nil, errors.Errorf("invalid width of {%s}", spec)
+			}
+			p.field, p.width = spec[:j], width
+		}
+		if p.field == "" {
+			return nil, errors.Errorf("empty field name {%s}", spec)
+		}
+		t.parts = append(t.parts, p)
+		t.named[p.field] = struct{}{}
+	}
+	return t, nil
+}
+
+// compactValue formats value in a single line, nested values as compact json
+func compactValue(value interface{}) (string, valueKind) {
+	kind := valueKindOf(value)
+	s, ok := formatScalar(value)
+	if value == nil {
+		s = "null"
+	} else if !ok {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", valueOther
+		}
+		s, kind = string(data), valueJSON
+	}
+	if kind == valueXML {
+		kind = valueString
+	}
+	return compactEscaper.Replace(s), kind
+}
+
+var compactEscaper = strings.NewReplacer("\r", `\r`, "\n", `\n`, "\t", `\t`)
+
+// render returns segments of compact line of fields
+func (t *compactTemplate) render(fields []recordField) []compactSegment {
+	values := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		values[f.key] = f.value
+	}
+	var segments []compactSegment
+	text := func(s string) {
+		segments = append(segments, compactSegment{text: s})
+	}
+	// skipSpace drops separator after missing field, so there are no double spaces
+	skipSpace := false
+	for _, p := range t.parts {
+		switch {
+		case p.rest:
+			first := true
+			for _, f := range fields {
+				if _, ok := t.named[f.key]; ok {
+					continue
+				}
+				if !first {
+					text(" ")
+				}
+				first = false
+				s, kind := compactValue(f.value)
+				if s == "" || strings.ContainsAny(s, " =\"") {
+					s, kind = strconv.Quote(s), valueString
+				}
+				segments = append(segments, compactSegment{text: f.key, key: true})
+				text("=")
+				segments = append(segments, compactSegment{text: s, kind: kind})
+			}
+			skipSpace = first
+		case p.field != "":
+			// named columns keep line color, only remaining fields are styled by type
+			s := ""
+			if value, ok := values[p.field]; ok {
+				s, _ = compactValue(value)
+			}
+			if s != "" {
+				text(s)
+			}
+			if n := utf8.RuneCountInString(s); n < p.width {
+				text(strings.Repeat(" ", p.width-n))
+			}
+			skipSpace = s == "" && p.width == 0
+		default:
+			s := p.text
+			if skipSpace {
+				if len(segments) == 0 || strings.HasSuffix(segments[len(segments)-1].text, " ") {
+					s = strings.TrimPrefix(s, " ")
+				}
+			}
+			skipSpace = false
+			if s != "" {
+				text(s)
+			}
+		}
+	}
+	for len(segments) > 0 && strings.TrimRight(segments[len(segments)-1].text, " ") == "" && !segments[len(segments)-1].key {
+		segments = segments[:len(segments)-1]
+	}
+	return segments
+}
+
+// truncateSegments cuts segments to width runes, the last rune is replaced by ellipsis
+func truncateSegments(segments []compactSegment, width int) []compactSegment {
+	if width <= 0 {
+		return segments
+	}
+	total := 0
+	for _, s := range segments {
+		total += utf8.RuneCountInString(s.text)
+	}
+	if total <= width {
+		return segments
+	}
+	budget := width - 1
+	for i, s := range segments {
+		n := utf8.RuneCountInString(s.text)
+		if n < budget {
+			budget -= n
+			continue
+		}
+		cut := 0
+		for j := 0; j < budget; j++ {
+			_, size := utf8.DecodeRuneInString(s.text[cut:])
+			cut += size
+		}
+		segments[i].text = s.text[:cut]
+		segments = segments[:i+1]
+		break
+	}
+	return append(segments, compactSegment{text: compactEllipsis})
+}
+
+// writeCompact writes record as single stdout line
+func (r *formattedRecord) writeCompact(level logLevel, fields []recordField) {
+	if r.hideDebug && !level.IsInfoOrHigher() {
+		return
+	}
+	segments := truncateSegments(r.compact.render(fields), r.compactWidth.get())
+	b := &r.stdout
+	if !r.needColors {
+		for _, s := range segments {
+			b.WriteString(s.text)
+		}
+		b.WriteByte('\n')
+		return
+	}
+	lineColor := r.theme.level(level)
+	b.WriteString(lineColor)
+	for _, s := range segments {
+		r.writeCompactSegment(b, s, lineColor)
+	}
+	b.WriteString(resetStyle)
+	b.WriteByte('\n')
+}
+
+func (r *formattedRecord) writeCompactSegment(b *bytes.Buffer, s compactSegment, lineColor string) {
+	var spans []styledSpan
+	switch {
+	case s.key && r.theme.key != "":
+		spans = []styledSpan{{0, len(s.text), r.theme.key}}
+	case !s.key && s.kind != valueOther:
+		spans = r.theme.valueSpans(s.text, s.kind)
+	}
+	writeSpans(b, s.text, spans, highlightSpans(s.text, r.highlights), r.theme.highlightStyle(), lineColor)
+}
+
+// terminalWidth is stdout terminal width updated on resize, zero if stdout is not a terminal
+type terminalWidth struct {
+	cols int32
+}
+
+func watchTerminalWidth(fd int) *terminalWidth {
+	w := &terminalWidth{}
+	if !isTerminal(fd) {
+		return w
+	}
+	update := func() {
+		if _, cols, err := terminalSize(fd); err == nil {
+			atomic.StoreInt32(&w.cols, int32(cols))
+		}
+	}
+	update()
+	resize := make(chan os.Signal, 1)
+	notifyResize(resize)
+	go func() {
+		for range resize {
+			update()
+		}
+	}()
+	return w
+}
+
+func (w *terminalWidth) get() int {
+	if w == nil {
+		return 0
+	}
+	return int(atomic.LoadInt32(&w.cols))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// uuidRegexp matches UUIDs such as MessageID, ShellId and CommandId
+var uuidRegexp = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
+// placeholderRegexp matches placeholders of already normalized fixtures, uuidRegexp does not match them
+var placeholderRegexp = regexp.MustCompile(`\buuid-[0-9]{4,}\b`)
+
+// volatileHeaders are removed from normalized fixtures
+var volatileHeaders = map[string]struct{}{
+	"date":           {},
+	"content-length": {},
+}
+
+// fixtureNormalizer rewrites fixture to be stable between runs: UUIDs are replaced by sequential
+// placeholders in order of appearance, the same UUID gets the same placeholder in both fixture files,
+// volatile headers are removed, credential headers are redacted and object keys are sorted.
+// Placeholders of already normalized fixtures are kept, so normalization is stable
+type fixtureNormalizer struct {
+	redact map[string]struct{}
+	uuids  map[string]string
+	// used are placeholders assigned or found in normalized input, new UUIDs never reuse them
+	used map[string]struct{}
+	next int
+}
+
+func newFixtureNormalizer(redact map[string]struct{}) *fixtureNormalizer {
+	return &fixtureNormalizer{
+		redact: redact,
+		uuids:  make(map[string]string),
+		used:   make(map[string]struct{}),
+	}
+}
+
+// normalize converts value to generic json and rewrites it
+func (n *fixtureNormalizer) normalize(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshal failed")
+	}
+	for _, p := range placeholderRegexp.FindAll(data, -1) {
+		n.used[string(p)] = struct{}{}
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var generic interface{}
+	if err := d.Decode(&generic); err != nil {
+		return nil, errors.Wrap(err, "Decode failed")
+	}
+	return n.walk("", generic), nil
+}
+
+// walk visits object keys in sorted order, so placeholders do not depend on map iteration order
+func (n *fixtureNormalizer) walk(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		headers := strings.EqualFold(key, "headers")
+		for _, k := range keys {
+			if headers {
+				lower := strings.ToLower(k)
+				if _, ok := volatileHeaders[lower]; ok {
+					delete(v, k)
+					continue
+				}
+				if _, ok := n.redact[lower]; ok {
+					v[k] = []interface{}{"REDACTED"}
+					continue
+				}
+			}
+			v[k] = n.walk(k, v[k])
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = n.walk(key, item)
+		}
+		return v
+	case string:
+		return uuidRegexp.ReplaceAllStringFunc(v, n.placeholder)
+	default:
+		return v
+	}
+}
+
+// placeholder returns sequential placeholder such as uuid-0001, case of UUID is ignored
+func (n *fixtureNormalizer) placeholder(uuid string) string {
+	key := strings.ToLower(uuid)
+	p, ok := n.uuids[key]
+	if ok {
+		return p
+	}
+	for {
+		n.next++
+		p = fmt.Sprintf("uuid-%04d", n.next)
+		if _, ok := n.used[p]; !ok {
+			break
+		}
+	}
+	n.uuids[key] = p
+	n.used[p] = struct{}{}
+	return p
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func normalizeTestJSON(t *testing.T, n *fixtureNormalizer, s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	got, err := n.normalize(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestFixtureNormalize(t *testing.T) {
+	input := `[{"request_id":"r1","headers":{"Date":["x"],"Authorization":["Basic x"],"Content-Type":["text/xml"]},` +
+		`"body":"<MessageID>uuid:4F2A9C0E-7B1D-4E3F-8A6B-5C4D3E2F1A0B</MessageID><ShellId>11111111-2222-3333-4444-555555555555</ShellId>"},` +
+		`{"request_id":"r2","body":"<RelatesTo>uuid:4f2a9c0e-7b1d-4e3f-8a6b-5c4d3e2f1a0b</RelatesTo>"}]`
+	got := normalizeTestJSON(t, newFixtureNormalizer(map[string]struct{}{"authorization": {}}), input)
+	want := []interface{}{
+		map[string]interface{}{
+			"request_id": "r1",
+			"headers": map[string]interface{}{
+				"Authorization": []interface{}{"REDACTED"},
+				"Content-Type":  []interface{}{"text/xml"},
+			},
+			"body": "<MessageID>uuid:uuid-0001</MessageID><ShellId>uuid-0002</ShellId>",
+		},
+		map[string]interface{}{
+			"request_id": "r2",
+			"body":       "<RelatesTo>uuid:uuid-0001</RelatesTo>",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v", got)
+	}
+
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := normalizeTestJSON(t, newFixtureNormalizer(nil), string(data))
+	if !reflect.DeepEqual(again, want) {
+		t.Errorf("normalization is not stable: %#v", again)
+	}
+}
+
+func TestFixtureNormalizeKeepsPlaceholdersDistinct(t *testing.T) {
+	// normalized fixture merged with captured one: new UUIDs do not reuse existing placeholders
+	input := `[{"id":"uuid-0001","other":"uuid-0002"},{"id":"aaaaaaaa-0000-0000-0000-000000000001","other":"uuid-0001"}]`
+	got := normalizeTestJSON(t, newFixtureNormalizer(nil), input)
+	want := []interface{}{
+		map[string]interface{}{"id": "uuid-0001", "other": "uuid-0002"},
+		map[string]interface{}{"id": "uuid-0003", "other": "uuid-0001"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v", got)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// fixtureProtocol decodes protocol specific data of request-response pairs, e.g. winrm soap envelopes.
+// Protocol is selected by request and the same protocol handles its response
+type fixtureProtocol struct {
+	name     string
+	match    func(l *logLine) bool
+	request  func(f *fixture, pair *requestResponse, l *logLine)
+	response func(f *fixture, pair *requestResponse, l *logLine)
+}
+
+// fixtureProtocols are checked in order, first match wins. Pairs without protocol keep only http data
+var fixtureProtocols []*fixtureProtocol
+
+func init() {
+	registerFixtureProtocol(&fixtureProtocol{
+		name:     "winrm",
+		match:    matchWinRM,
+		request:  winrmFixtureRequest,
+		response: winrmFixtureResponse,
+	})
+}
+
+// registerFixtureProtocol adds protocol to the registry
+func registerFixtureProtocol(p *fixtureProtocol) {
+	fixtureProtocols = append(fixtureProtocols, p)
+}
+
+func findFixtureProtocol(l *logLine) *fixtureProtocol {
+	for _, p := range fixtureProtocols {
+		if p.match(l) {
+			return p
+		}
+	}
+	return nil
+}
+
+// newFixtureBody stores json body as structured data and xml body as decoded node,
+// keepXMLString keeps body string of decoded xml
+func newFixtureBody(l *logLine, keepXMLString bool) bodyWithHeaders {
+	b := bodyWithHeaders{
+		Headers:    l.Headers,
+		BodyString: l.BodyString,
+	}
+	contentType := strings.ToLower(headerGet(l.Headers, "Content-Type"))
+	switch {
+	case l.BodyString == "":
+	case strings.Contains(contentType, "xml") || sniffXMLBody(l.BodyString):
+		n, err := decodeXML(l.BodyString)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error parse %s xml %s body in %+v\n", err, l.Message, *l)
+			return b
+		}
+		b.BodyData = n
+		if !keepXMLString {
+			b.BodyString = ""
+		}
+	case strings.Contains(contentType, "json") || sniffJSONBody(l.BodyString):
+		d := json.NewDecoder(bytes.NewReader([]byte(l.BodyString)))
+		d.UseNumber()
+		var data interface{}
+		if err := d.Decode(&data); err != nil {
+			fmt.Fprintf(os.Stderr, "error parse %s json %s body in %+v\n", err, l.Message, *l)
+			return b
+		}
+		b.BodyJSON = data
+		b.BodyString = ""
+	}
+	return b
+}
+
+// matchWinRM selects soap envelopes sent to wsman endpoint
+func matchWinRM(l *logLine) bool {
+	return sniffXMLBody(l.BodyString) && (strings.Contains(l.Url, "/wsman") || strings.Contains(l.BodyString, "/wsman"))
+}
+
+func winrmFixtureRequest(f *fixture, pair *requestResponse, l *logLine) {
+	r, err := parseRequest(l.BodyString)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error parse %s winrm soap request body in %+v\n", err, *l)
+		return
+	}
+	pair.SOAPRequest = r
+	f.processRequest(r)
+}
+
+func winrmFixtureResponse(f *fixture, pair *requestResponse, l *logLine) {
+	r, err := parseResponse(l.BodyString)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error parse %s winrm soap response body in %+v\n", err, *l)
+	}
+	if r == nil {
+		return
+	}
+	pair.SOAPResponse = r
+	f.processResponse(l.RequestID, r)
+}
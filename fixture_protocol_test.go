@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func processTestRecords(t *testing.T, f *fixture, lines ...string) {
+	for _, line := range lines {
+		record, err := parseRecord([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.processRecord(record)
+	}
+}
+
+func TestFixtureProcessPlainHTTP(t *testing.T) {
+	f := newFixture()
+	processTestRecords(t, f,
+		`{"time":"2021-06-01T10:00:00Z","msg":"http_request","request_id":"r1","url":"https://api/items","method":"POST",`+
+			`"headers":{"Content-Type":["application/json"]},"body_string":"{\"name\":\"a\",\"count\":2}"}`,
+		`{"time":"2021-06-01T10:00:01Z","msg":"http_response","request_id":"r1","status_code":201,"status":"201 Created",`+
+			`"headers":{"Content-Type":["application/json"]},"body_string":"[{\"id\":1}]"}`,
+		`{"time":"2021-06-01T10:00:02Z","msg":"http_request","request_id":"r2","url":"https://api/health","method":"GET","body_string":""}`,
+		`{"time":"2021-06-01T10:00:03Z","msg":"http_response","request_id":"r2","status_code":200,`+
+			`"headers":{"Content-Type":["text/plain"]},"body_string":"ok"}`,
+	)
+
+	data, err := f.marshal(f.data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []struct {
+		RequestID    string          `json:"request_id"`
+		StatusCode   int             `json:"status_code"`
+		Request      json.RawMessage `json:"request"`
+		Response     json.RawMessage `json:"response"`
+		SOAPRequest  json.RawMessage `json:"soap_request"`
+		SOAPResponse json.RawMessage `json:"soap_response"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("captured %d pairs:\n%s", len(got), data)
+	}
+	tests := []struct {
+		body json.RawMessage
+		want map[string]interface{}
+	}{
+		{got[0].Request, map[string]interface{}{"body_json": map[string]interface{}{"name": "a", "count": 2.0}}},
+		{got[0].Response, map[string]interface{}{"body_json": []interface{}{map[string]interface{}{"id": 1.0}}}},
+		{got[1].Request, map[string]interface{}{}},
+		{got[1].Response, map[string]interface{}{"body_string": "ok"}},
+	}
+	for i, tt := range tests {
+		var body map[string]interface{}
+		if err := json.Unmarshal(tt.body, &body); err != nil {
+			t.Fatal(err)
+		}
+		delete(body, "headers")
+		if !reflect.DeepEqual(body, tt.want) {
+			t.Errorf("body %d: got %s", i, tt.body)
+		}
+	}
+	if got[0].StatusCode != 201 || string(got[0].SOAPRequest) != "null" || string(got[1].SOAPResponse) != "null" {
+		t.Errorf("pairs %s", data)
+	}
+	if f.data[0].protocol != nil || len(f.commandResponses) != 0 {
+		t.Errorf("plain http is decoded by protocol %+v", f.data[0].protocol)
+	}
+	if f.data[1].responseBody != "ok" || f.data[0].requestTime.IsZero() {
+		t.Errorf("raw body or time is not kept: %+v", f.data[1])
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// runFixture runs `log_decoder fixture merge|diff` subcommands
+func runFixture(args []string) {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "usage:\n  %s fixture merge [-normalize] -o <fixture> <fixture>...\n  %s fixture diff <old fixture> <new fixture>\n", os.Args[0], os.Args[0])
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	switch args[0] {
+	case "merge":
+		flags := flag.NewFlagSet("fixture merge", flag.ExitOnError)
+		output := flags.String("o", "", "filename to write merged fixture, responses are written to filename + _responses.json")
+		normalize := flags.Bool("normalize", false, "normalize merged fixture: sequential placeholders of uuids, no volatile headers, sorted keys")
+		redactFields := flags.String("redact", "authorization,password,token,cookie,set-cookie", "comma separated headers redacted by -normalize")
+		_ = flags.Parse(args[1:])
+		if *output == "" || flags.NArg() == 0 {
+			usage()
+		}
+		merged := newFixture()
+		for _, filename := range flags.Args() {
+			f, err := loadFixture(filename)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Load fixture error %s\n", err)
+				os.Exit(1)
+			}
+			merged.merge(f, filename)
+		}
+		if *normalize {
+			merged.normalizer = newFixtureNormalizer(parseRedactFields(*redactFields))
+		}
+		if err := merged.SaveToFile(*output); err != nil {
+			fmt.Fprintf(os.Stderr, "SaveToFile error %s\n", err)
+			os.Exit(1)
+		}
+	case "diff":
+		if len(args) != 3 {
+			usage()
+		}
+		old, err := loadFixture(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Load fixture error %s\n", err)
+			os.Exit(1)
+		}
+		current, err := loadFixture(args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Load fixture error %s\n", err)
+			os.Exit(1)
+		}
+		if diffFixtures(os.Stdout, old, current) {
+			os.Exit(1)
+		}
+	default:
+		usage()
+	}
+}
+
+// loadFixture reads fixture written by SaveToFile, responses file is optional
+func loadFixture(filename string) (*fixture, error) {
+	f := newFixture()
+	if err := readFixtureJSON(filename, &f.data); err != nil {
+		return nil, err
+	}
+	for _, pair := range f.data {
+		f.requestDict[pair.RequestID] = pair
+	}
+	responses := filename + "_responses.json"
+	if _, err := os.Stat(responses); err == nil {
+		if err := readFixtureJSON(responses, &f.commandResponses); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// readFixtureJSON decodes json file keeping numbers as json.Number, so values are written back unchanged
+func readFixtureJSON(filename string, v interface{}) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return errors.Wrap(err, "ReadFile failed")
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(v); err != nil {
+		return errors.Wrapf(err, "decode %s failed", filename)
+	}
+	return nil
+}
+
+// merge appends pairs and command responses of other fixture, skipping identical pairs
+// and commands with already known command key. Different responses of known command are reported
+func (f *fixture) merge(other *fixture, source string) {
+	seenPairs := make(map[string]struct{}, len(f.data))
+	for _, pair := range f.data {
+		seenPairs[fixtureJSONKey(pair)] = struct{}{}
+	}
+	for _, pair := range other.data {
+		key := fixtureJSONKey(pair)
+		if _, ok := seenPairs[key]; ok {
+			continue
+		}
+		seenPairs[key] = struct{}{}
+		f.data = append(f.data, pair)
+		if pair.RequestID != "" {
+			f.requestDict[pair.RequestID] = pair
+		}
+	}
+	known := make(map[string]*commandResponse, len(f.commandResponses))
+	for _, cr := range f.commandResponses {
+		if _, ok := known[cr.Command]; !ok {
+			known[cr.Command] = cr
+		}
+	}
+	for _, cr := range other.commandResponses {
+		if existing, ok := known[cr.Command]; ok {
+			if fixtureJSONKey(existing) != fixtureJSONKey(cr) {
+				fmt.Fprintf(os.Stderr, "%s: command %s has different response, first one is kept\n", source, cr.Command)
+			}
+			continue
+		}
+		known[cr.Command] = cr
+		f.commandResponses = append(f.commandResponses, cr)
+	}
+}
+
+// fixtureJSONKey returns json encoding of value, maps are encoded with sorted keys
+func fixtureJSONKey(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%p", v)
+	}
+	return string(data)
+}
+
+// diffFixtures writes commands added or removed, changed exit codes and changed stdout of new fixture
+// compared to old one. Repeated commands are compared by occurrence. Returns true if fixtures differ
+func diffFixtures(w io.Writer, old, current *fixture) bool {
+	oldCommands, oldKeys := indexCommands(old.commandResponses)
+	newCommands, newKeys := indexCommands(current.commandResponses)
+	differ := false
+	for _, key := range oldKeys {
+		if _, ok := newCommands[key]; !ok {
+			fmt.Fprintf(w, "- command %s\n", key)
+			differ = true
+		}
+	}
+	for _, key := range newKeys {
+		n := newCommands[key]
+		o, ok := oldCommands[key]
+		if !ok {
+			fmt.Fprintf(w, "+ command %s\n", key)
+			differ = true
+			continue
+		}
+		if o.ExitCode != n.ExitCode {
+			fmt.Fprintf(w, "~ command %s: exit code %d -> %d\n", key, o.ExitCode, n.ExitCode)
+			differ = true
+		}
+		if fixtureJSONKey(o.Response) != fixtureJSONKey(n.Response) {
+			fmt.Fprintf(w, "~ command %s: stdout json changed\n", key)
+			writeJSONDiff(w, o.Response, n.Response)
+			differ = true
+		} else if o.ResponseString != n.ResponseString {
+			fmt.Fprintf(w, "~ command %s: stdout changed\n", key)
+			writeLinesDiff(w, flattenLines(o.ResponseString), flattenLines(n.ResponseString))
+			differ = true
+		}
+	}
+	return differ
+}
+
+// indexCommands maps command key to response, repeated command keys get ` #n` suffix
+func indexCommands(responses []*commandResponse) (map[string]*commandResponse, []string) {
+	index := make(map[string]*commandResponse, len(responses))
+	keys := make([]string, 0, len(responses))
+	count := make(map[string]int)
+	for _, cr := range responses {
+		count[cr.Command]++
+		key := cr.Command
+		if n := count[cr.Command]; n > 1 {
+			key = fmt.Sprintf("%s #%d", cr.Command, n)
+		}
+		index[key] = cr
+		keys = append(keys, key)
+	}
+	return index, keys
+}
+
+// writeJSONDiff writes changed `path = value` lines of flattened json values
+func writeJSONDiff(w io.Writer, old, current interface{}) {
+	var oldLines, newLines []string
+	flattenJSON("", old, &oldLines)
+	flattenJSON("", current, &newLines)
+	writeLinesDiff(w, oldLines, newLines)
+}
+
+// writeLinesDiff writes lines missing in new as `-` and lines missing in old as `+`
+func writeLinesDiff(w io.Writer, old, current []string) {
+	oldSet := make(map[string]int, len(old))
+	for _, l := range old {
+		oldSet[l]++
+	}
+	newSet := make(map[string]int, len(current))
+	for _, l := range current {
+		newSet[l]++
+	}
+	for _, l := range old {
+		if newSet[l] > 0 {
+			newSet[l]--
+			continue
+		}
+		fmt.Fprintf(w, "    - %s\n", l)
+	}
+	for _, l := range current {
+		if oldSet[l] > 0 {
+			oldSet[l]--
+			continue
+		}
+		fmt.Fprintf(w, "    + %s\n", l)
+	}
+}
+
+// flattenJSON converts json value to sorted `path = value` lines
+func flattenJSON(path string, value interface{}, lines *[]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			flattenJSON(path+"."+k, v[k], lines)
+		}
+	case []interface{}:
+		for i, item := range v {
+			flattenJSON(fmt.Sprintf("%s[%d]", path, i), item, lines)
+		}
+	default:
+		if path == "" {
+			path = "."
+		}
+		*lines = append(*lines, path+" = "+fixtureJSONKey(v))
+	}
+}
+
+func flattenLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimRight(s, "\r\n"), "\n")
+}
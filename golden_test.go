@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// runMainEnv makes test binary run main with its arguments instead of tests
+const runMainEnv = "LOG_DECODER_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// goldenCases are flags of testdata/golden/<name> outputs. Expected outputs were written by
+// the baseline decoder before parallel decoding and tokenizer, stdout with color escapes removed
+var goldenCases = []struct {
+	name string
+	args []string
+}{
+	{"default", nil},
+	{"hidedebug", []string{"-hidedebug"}},
+	{"skip", []string{"-skipempty", "-skip", "caller,obj"}},
+}
+
+func TestGoldenOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join("testdata", "golden", "input.log")
+
+	for _, tc := range goldenCases {
+		for _, workers := range []int{1, 8} {
+			prefix := filepath.Join(dir, fmt.Sprintf("%s_%d", tc.name, workers))
+			args := append([]string{"-color", "never", "-workers", fmt.Sprint(workers), "-prefix", prefix}, tc.args...)
+			stdin, err := os.Open(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stdout, stderr bytes.Buffer
+			cmd := exec.Command(os.Args[0], args...)
+			cmd.Env = append(os.Environ(), runMainEnv+"=1")
+			cmd.Stdin = stdin
+			cmd.Stdout = &stdout
+			cmd.Stderr = &stderr
+			err = cmd.Run()
+			stdin.Close()
+			if err != nil || stderr.Len() > 0 {
+				t.Fatalf("%s -workers %d: %v %s", tc.name, workers, err, stderr.Bytes())
+			}
+
+			expected := filepath.Join("testdata", "golden", tc.name)
+			compareGolden(t, tc.name, workers, filepath.Join(expected, "stdout"), stdout.Bytes())
+			for _, kind := range []string{"decoded", "info", "error", "original"} {
+				got, err := ioutil.ReadFile(fmt.Sprintf("%s_log_%s.log", prefix, kind))
+				if err != nil {
+					t.Fatal(err)
+				}
+				compareGolden(t, tc.name, workers, filepath.Join(expected, kind+".log"), got)
+			}
+		}
+	}
+}
+
+func compareGolden(t *testing.T, name string, workers int, filename string, got []byte) {
+	want, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(got, want) {
+		return
+	}
+	line := 1 + bytes.Count(got[:commonPrefix(got, want)], []byte("\n"))
+	t.Errorf("%s -workers %d: output differs from %s at line %d", name, workers, filename, line)
+}
+
+func commonPrefix(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
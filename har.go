@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// harTimeFormat is ISO 8601 with milliseconds used by HAR startedDateTime
+const harTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// har types follow HAR 1.2 specification, custom fields start with underscore
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string      `json:"version"`
+	Creator harCreator  `json:"creator"`
+	Pages   []struct{}  `json:"pages"`
+	Entries []*harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	RequestID       string      `json:"_requestId,omitempty"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// SaveHAR writes request-response pairs as HAR 1.2, timings are taken from record timestamps.
+// Requests without response have status 0
+func (f *fixture) SaveHAR(filename string) error {
+	h := harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "log_decoder", Version: "1.0"},
+		Pages:   []struct{}{},
+		Entries: make([]*harEntry, 0, len(f.data)),
+	}}
+	for _, pair := range f.data {
+		h.Log.Entries = append(h.Log.Entries, newHAREntry(pair))
+	}
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "MarshalIndent failed")
+	}
+	if err := ioutil.WriteFile(filename, data, 0660); err != nil {
+		return errors.Wrap(err, "WriteFile failed")
+	}
+	return nil
+}
+
+func newHAREntry(pair *requestResponse) *harEntry {
+	started := pair.requestTime
+	if started.IsZero() {
+		started = time.Unix(0, 0).UTC()
+	}
+	e := &harEntry{
+		StartedDateTime: started.Format(harTimeFormat),
+		RequestID:       pair.RequestID,
+		Request: harRequest{
+			Method:      pair.Method,
+			URL:         pair.Url,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     harCookies((&http.Request{Header: canonicalHeader(pair.Request.Headers)}).Cookies()),
+			Headers:     harHeaders(pair.Request.Headers),
+			QueryString: harQueryString(pair.Url),
+			HeadersSize: -1,
+			BodySize:    len(pair.requestBody),
+		},
+		Response: harResponse{
+			Status:      pair.StatusCode,
+			StatusText:  strings.TrimSpace(strings.TrimPrefix(pair.Status, strconv.Itoa(pair.StatusCode))),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     harCookies((&http.Response{Header: canonicalHeader(pair.Response.Headers)}).Cookies()),
+			Headers:     harHeaders(pair.Response.Headers),
+			Content: harContent{
+				Size:     len(pair.responseBody),
+				MimeType: headerGet(pair.Response.Headers, "Content-Type"),
+				Text:     pair.responseBody,
+			},
+			HeadersSize: -1,
+			BodySize:    len(pair.responseBody),
+		},
+		// send, wait and receive must not be negative, unknown timings are 0
+		Timings: harTimings{Send: 0, Wait: 0, Receive: 0},
+	}
+	if pair.requestBody != "" {
+		e.Request.PostData = &harPostData{
+			MimeType: headerGet(pair.Request.Headers, "Content-Type"),
+			Text:     pair.requestBody,
+		}
+	}
+	switch {
+	case pair.Response.Headers == nil && pair.StatusCode == 0 && pair.responseBody == "":
+		e.Comment = "no response"
+		e.Response.BodySize = -1
+	case !pair.requestTime.IsZero() && !pair.responseTime.IsZero():
+		wait := float64(pair.responseTime.Sub(pair.requestTime)) / float64(time.Millisecond)
+		if wait < 0 {
+			wait = 0
+		}
+		e.Time = wait
+		e.Timings.Wait = wait
+	}
+	return e
+}
+
+// harHeaders converts headers to name-value list sorted by name
+func harHeaders(headers http.Header) []harNameValue {
+	list := make([]harNameValue, 0, len(headers))
+	for name, values := range headers {
+		for _, v := range values {
+			list = append(list, harNameValue{Name: name, Value: v})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// headerGet returns first value of header matching name case-insensitively,
+// logged headers keep the case used by client, e.g. content-type
+func headerGet(headers http.Header, name string) string {
+	if values := headers[http.CanonicalHeaderKey(name)]; len(values) > 0 {
+		return values[0]
+	}
+	for _, k := range sortedHeaderNames(headers) {
+		if strings.EqualFold(k, name) && len(headers[k]) > 0 {
+			return headers[k][0]
+		}
+	}
+	return ""
+}
+
+// canonicalHeader copies headers with canonical names, so http.Header methods find them
+func canonicalHeader(headers http.Header) http.Header {
+	c := make(http.Header, len(headers))
+	for _, k := range sortedHeaderNames(headers) {
+		name := http.CanonicalHeaderKey(k)
+		c[name] = append(c[name], headers[k]...)
+	}
+	return c
+}
+
+func harCookies(cookies []*http.Cookie) []harNameValue {
+	list := make([]harNameValue, 0, len(cookies))
+	for _, c := range cookies {
+		list = append(list, harNameValue{Name: c.Name, Value: c.Value})
+	}
+	return list
+}
+
+func harQueryString(rawURL string) []harNameValue {
+	list := []harNameValue{}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return list
+	}
+	query := u.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range query[name] {
+			list = append(list, harNameValue{Name: name, Value: v})
+		}
+	}
+	return list
+}
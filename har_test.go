@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHAREntryStartedDateTime(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("test", 3*60*60)
+	defer func() { time.Local = local }()
+
+	if got := newHAREntry(&requestResponse{}).StartedDateTime; got != "1970-01-01T00:00:00.000Z" {
+		t.Errorf("entry without time started at %s", got)
+	}
+	requestTime := time.Date(2021, 6, 1, 10, 0, 0, 0, time.FixedZone("", -2*60*60))
+	if got := newHAREntry(&requestResponse{requestTime: requestTime}).StartedDateTime; got != "2021-06-01T10:00:00.000-02:00" {
+		t.Errorf("entry started at %s", got)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// defaultSyntaxSpec colors field names and values by type, enabled by -syntax flag
+const defaultSyntaxSpec = "key=bold,value.string=cyan,value.number=magenta,value.bool=magenta,value.null=bright-black," +
+	"json.key=blue,xml.tag=blue,xml.attr=bright-blue,highlight=reverse"
+
+// defaultHighlightStyle marks -highlight matches when theme has no highlight style
+const defaultHighlightStyle = "\u001b[7m"
+
+// syntaxTargets are theme targets of value syntax coloring
+var syntaxTargets = map[string]struct{}{
+	"value.string": {},
+	"value.number": {},
+	"value.bool":   {},
+	"value.null":   {},
+	"json.key":     {},
+	"xml.tag":      {},
+	"xml.attr":     {},
+	"highlight":    {},
+}
+
+// valueKind selects syntax coloring of formatted field value
+type valueKind int
+
+const (
+	valueOther valueKind = iota
+	valueString
+	valueNumber
+	valueBool
+	valueNull
+	valueJSON
+	valueXML
+)
+
+// styledSpan is a styled byte range of formatted value
+type styledSpan struct {
+	start, end int
+	style      string
+}
+
+// withSyntaxTheme puts default syntax styles before theme spec, so theme items override them
+func withSyntaxTheme(spec string) string {
+	if spec == "none" || strings.HasPrefix(spec, "none,") {
+		return spec
+	}
+	return defaultSyntaxSpec + "," + spec
+}
+
+// compileHighlights compiles -highlight regular expressions
+func compileHighlights(exprs []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// valueKindOf returns syntax kind of decoded field value, strings with xml or json are highlighted as such
+func valueKindOf(value interface{}) valueKind {
+	switch v := value.(type) {
+	case string:
+		if sniffXMLBody(v) {
+			return valueXML
+		}
+		if sniffJSONBody(v) {
+			return valueJSON
+		}
+		return valueString
+	case json.Number:
+		return valueNumber
+	case bool:
+		return valueBool
+	case nil:
+		return valueNull
+	default:
+		return valueOther
+	}
+}
+
+// valueSpans returns styled ranges of formatted value of kind
+func (t *colorTheme) valueSpans(s string, kind valueKind) []styledSpan {
+	var style string
+	switch kind {
+	case valueJSON:
+		return t.jsonSpans(s)
+	case valueXML:
+		return t.xmlSpans(s)
+	case valueString:
+		style = t.syntax["value.string"]
+	case valueNumber:
+		style = t.syntax["value.number"]
+	case valueBool:
+		style = t.syntax["value.bool"]
+	case valueNull:
+		style = t.syntax["value.null"]
+	}
+	if style == "" || s == "" {
+		return nil
+	}
+	return []styledSpan{{0, len(s), style}}
+}
+
+// jsonSpans tokenizes json text: object keys, strings, numbers, booleans and nulls
+func (t *colorTheme) jsonSpans(s string) []styledSpan {
+	var spans []styledSpan
+	add := func(start, end int, target string) {
+		if style := t.syntax[target]; style != "" {
+			spans = append(spans, styledSpan{start, end, style})
+		}
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '"':
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end < len(s) {
+				end++
+			}
+			j := end
+			for j < len(s) && (s[j] == ' ' || s[j] == '\t') {
+				j++
+			}
+			if j < len(s) && s[j] == ':' {
+				add(i, end, "json.key")
+			} else {
+				add(i, end, "value.string")
+			}
+			i = end
+		case c == '-' || (c >= '0' && c <= '9'):
+			end := i + 1
+			for end < len(s) && strings.IndexByte("0123456789.eE+-", s[end]) >= 0 {
+				end++
+			}
+			add(i, end, "value.number")
+			i = end
+		case c >= 'a' && c <= 'z':
+			end := i + 1
+			for end < len(s) && s[end] >= 'a' && s[end] <= 'z' {
+				end++
+			}
+			switch s[i:end] {
+			case "true", "false":
+				add(i, end, "value.bool")
+			case "null":
+				add(i, end, "value.null")
+			}
+			i = end
+		default:
+			i++
+		}
+	}
+	return spans
+}
+
+// xmlSpans tokenizes xml text: tags, attribute names and values and comments, text is not styled
+func (t *colorTheme) xmlSpans(s string) []styledSpan {
+	var spans []styledSpan
+	add := func(start, end int, target string) {
+		if style := t.syntax[target]; style != "" && end > start {
+			spans = append(spans, styledSpan{start, end, style})
+		}
+	}
+	isSpace := func(c byte) bool {
+		return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+	}
+	for i := 0; i < len(s); {
+		if s[i] != '<' {
+			i++
+			continue
+		}
+		if strings.HasPrefix(s[i:], "<!--") {
+			end := strings.Index(s[i:], "-->")
+			if end < 0 {
+				end = len(s)
+			} else {
+				end += i + 3
+			}
+			add(i, end, "value.null")
+			i = end
+			continue
+		}
+		// tag name including <, </, <? and <!
+		end := i + 1
+		for end < len(s) && !isSpace(s[end]) && s[end] != '>' && !(s[end] == '/' && end > i+1) {
+			end++
+		}
+		add(i, end, "xml.tag")
+		i = end
+		for i < len(s) && s[i] != '>' {
+			switch {
+			case isSpace(s[i]) || s[i] == '=':
+				i++
+			case s[i] == '"' || s[i] == '\'':
+				end := strings.IndexByte(s[i+1:], s[i])
+				if end < 0 {
+					end = len(s)
+				} else {
+					end += i + 2
+				}
+				add(i, end, "value.string")
+				i = end
+			case s[i] == '/' || s[i] == '?':
+				add(i, i+1, "xml.tag")
+				i++
+			default:
+				end := i
+				for end < len(s) && !isSpace(s[end]) && strings.IndexByte("=>/?", s[end]) < 0 {
+					end++
+				}
+				add(i, end, "xml.attr")
+				i = end
+			}
+		}
+		if i < len(s) {
+			add(i, i+1, "xml.tag")
+			i++
+		}
+	}
+	return spans
+}
+
+// highlightSpans returns merged ranges matched by any of highlight expressions
+func highlightSpans(s string, highlights []*regexp.Regexp) [][2]int {
+	var matches [][2]int
+	for _, re := range highlights {
+		for _, m := range re.FindAllStringIndex(s, -1) {
+			if m[1] > m[0] {
+				matches = append(matches, [2]int{m[0], m[1]})
+			}
+		}
+	}
+	if len(matches) < 2 {
+		return matches
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i][0] < matches[j][0] })
+	merged := matches[:1]
+	for _, m := range matches[1:] {
+		last := &merged[len(merged)-1]
+		if m[0] <= last[1] {
+			if m[1] > last[1] {
+				last[1] = m[1]
+			}
+			continue
+		}
+		merged = append(merged, m)
+	}
+	return merged
+}
+
+// writeSpans writes s with styled spans and highlighted ranges, restoring line color after each styled part.
+// Highlighted ranges take precedence over syntax styles
+func writeSpans(b *bytes.Buffer, s string, spans []styledSpan, highlights [][2]int, highlightStyle, lineColor string) {
+	styleAt := func(pos int) (string, int) {
+		// style of pos and end of the range with this style
+		for len(spans) > 0 && spans[0].end <= pos {
+			spans = spans[1:]
+		}
+		if len(spans) == 0 {
+			return "", len(s)
+		}
+		if spans[0].start > pos {
+			return "", spans[0].start
+		}
+		return spans[0].style, spans[0].end
+	}
+	pos := 0
+	for pos < len(s) {
+		for len(highlights) > 0 && highlights[0][1] <= pos {
+			highlights = highlights[1:]
+		}
+		style, end := styleAt(pos)
+		if len(highlights) > 0 {
+			h := highlights[0]
+			if h[0] <= pos {
+				style, end = highlightStyle, h[1]
+			} else if h[0] < end {
+				end = h[0]
+			}
+		}
+		if style == "" {
+			b.WriteString(s[pos:end])
+		} else {
+			b.WriteString(style)
+			b.WriteString(s[pos:end])
+			b.WriteString(resetStyle)
+			b.WriteString(lineColor)
+		}
+		pos = end
+	}
+}
+
+// highlightStyle returns style of -highlight matches
+func (t *colorTheme) highlightStyle() string {
+	if style := t.syntax["highlight"]; style != "" {
+		return style
+	}
+	return defaultHighlightStyle
+}
+
+func isSyntaxTarget(target string) bool {
+	_, ok := syntaxTargets[target]
+	return ok
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// htmlTreeOpenDepth is a depth up to which xml and json trees are expanded
+const htmlTreeOpenDepth = 2
+
+const htmlReportHeader = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; margin: 0; background: #f4f4f4; }
+#toolbar { position: sticky; top: 0; background: #333; color: #fff; padding: 6px 10px; z-index: 1; }
+#toolbar input[type=search] { width: 40em; }
+#records { padding: 8px; }
+details.card, details.group { background: #fff; border-left: 4px solid #999; margin: 3px 0; padding: 2px 6px; }
+details.group { border-left-color: #36c; }
+details.group > summary { font-weight: bold; }
+details.card > summary, details.group > summary { cursor: pointer; font-family: monospace; white-space: pre; overflow: hidden; text-overflow: ellipsis; }
+.lvl-trace, .lvl-debug { border-left-color: #3a3 !important; }
+.lvl-info { border-left-color: #999 !important; }
+.lvl-warn { border-left-color: #d90 !important; background: #fffbe8 !important; }
+.lvl-error { border-left-color: #c33 !important; background: #fff0f0 !important; }
+.field { font-family: monospace; margin: 2px 0 2px 12px; }
+.name { color: #36c; font-weight: bold; }
+pre { margin: 2px 0 2px 12px; white-space: pre-wrap; word-break: break-all; }
+.tree { margin-left: 16px; }
+.tree summary { cursor: pointer; }
+.key { color: #a3a; }
+.tag { color: #36c; }
+.attr { color: #a60; }
+.hidden { display: none; }
+</style>
+</head>
+<body>
+<div id="toolbar">
+<input type="search" id="search" placeholder="search">
+<label><input type="checkbox" id="group" checked> group by request_id</label>
+<span id="count"></span>
+</div>
+<div id="records">
+`
+
+const htmlReportFooter = `</div>
+<script>
+(function() {
+  var container = document.getElementById('records');
+  var cards = Array.prototype.slice.call(container.querySelectorAll('details.card'));
+  var groups = {};
+  function group(enabled) {
+    cards.forEach(function(card) { container.appendChild(card); });
+    Object.keys(groups).forEach(function(id) { groups[id].remove(); });
+    groups = {};
+    if (!enabled) { return; }
+    cards.forEach(function(card) {
+      var id = card.getAttribute('data-request');
+      if (!id) { return; }
+      var g = groups[id];
+      if (!g) {
+        g = document.createElement('details');
+        g.className = 'group';
+        var summary = document.createElement('summary');
+        summary.textContent = 'request_id ' + id;
+        g.appendChild(summary);
+        container.insertBefore(g, card);
+        groups[id] = g;
+      }
+      g.appendChild(card);
+      if (card.className.indexOf('lvl-error') >= 0 || card.className.indexOf('lvl-warn') >= 0) {
+        g.classList.add(card.className.indexOf('lvl-error') >= 0 ? 'lvl-error' : 'lvl-warn');
+      }
+    });
+    Object.keys(groups).forEach(function(id) {
+      var summary = groups[id].firstChild;
+      summary.textContent += ' (' + (groups[id].children.length - 1) + ' records)';
+    });
+  }
+  function search() {
+    var q = document.getElementById('search').value.toLowerCase();
+    var shown = 0;
+    cards.forEach(function(card) {
+      var match = !q || card.textContent.toLowerCase().indexOf(q) >= 0;
+      card.classList.toggle('hidden', !match);
+      if (match) { shown++; }
+    });
+    Object.keys(groups).forEach(function(id) {
+      var g = groups[id];
+      g.classList.toggle('hidden', !g.querySelector('details.card:not(.hidden)'));
+      if (q) { g.open = true; }
+    });
+    document.getElementById('count').textContent = shown + '/' + cards.length + ' records';
+  }
+  document.getElementById('search').addEventListener('input', search);
+  document.getElementById('group').addEventListener('change', function(e) { group(e.target.checked); search(); });
+  group(true);
+  search();
+})();
+</script>
+</body>
+</html>
+`
+
+// htmlWriteCloser writes report footer before closing file
+type htmlWriteCloser struct {
+	io.WriteCloser
+}
+
+func (h *htmlWriteCloser) Close() error {
+	_, err1 := io.WriteString(h.WriteCloser, htmlReportFooter)
+	err2 := h.WriteCloser.Close()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+// OpenHTML creates self-contained html report, existing report is replaced.
+// Report of suspended writer is reopened for append
+func (w *logWriter) OpenHTML(filename string) error {
+	if w.suspended {
+		var wr io.WriteCloser
+		if err := openLogFile(filename, w.bufferSize, &wr); err != nil {
+			return err
+		}
+		w.htmlWriter = &htmlWriteCloser{wr}
+		return nil
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrapf(err, "Create %s failed", filename)
+	}
+	wr := newBufferedWriterCloser(file, w.bufferSize)
+	if _, err := fmt.Fprintf(wr, htmlReportHeader, html.EscapeString(filename)); err != nil {
+		wr.Close()
+		return errors.Wrapf(err, "write %s failed", filename)
+	}
+	w.htmlWriter = &htmlWriteCloser{wr}
+	return nil
+}
+
+// writeHTMLCard writes record card with already rendered fields
+func (w *logWriter) writeHTMLCard(level logLevel, requestID, title, body string) {
+	if w.htmlWriter == nil {
+		return
+	}
+	_, err := fmt.Fprintf(w.htmlWriter, "<details class=\"card lvl-%s\" data-request=\"%s\"><summary>%s</summary>\n%s</details>\n",
+		logLevelName(level), html.EscapeString(requestID), html.EscapeString(title), body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WriteHTML error %s\n", err)
+	}
+}
+
+// writeHTMLField renders field as html, values with xml or json are shown as collapsible trees
+func writeHTMLField(b *strings.Builder, name string, value interface{}) {
+	b.WriteString("<div class=\"field\"><span class=\"name\">")
+	b.WriteString(html.EscapeString(name))
+	b.WriteString("</span>: ")
+	writeHTMLValue(b, value)
+	b.WriteString("</div>\n")
+}
+
+func writeHTMLValue(b *strings.Builder, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}, []interface{}:
+		writeJSONTree(b, "", v, 0)
+		return
+	case string:
+		if sniffXMLBody(v) {
+			if root, _, err := decodeRawXML(v); err == nil {
+				b.WriteString("<div class=\"tree\">")
+				writeXMLTree(b, root, 0)
+				b.WriteString("</div>")
+				return
+			}
+		}
+		if sniffJSONBody(v) {
+			d := json.NewDecoder(strings.NewReader(v))
+			d.UseNumber()
+			var data interface{}
+			if err := d.Decode(&data); err == nil {
+				writeJSONTree(b, "", data, 0)
+				return
+			}
+		}
+		if strings.Contains(v, "\n") {
+			b.WriteString("<pre>")
+			b.WriteString(html.EscapeString(v))
+			b.WriteString("</pre>")
+			return
+		}
+		b.WriteString(html.EscapeString(v))
+	default:
+		s, ok := formatScalar(value)
+		if !ok {
+			data, err := json.Marshal(value)
+			if err != nil {
+				s = fmt.Sprintf("%+v", value)
+			} else {
+				var decoded interface{}
+				if json.Unmarshal(data, &decoded) == nil {
+					writeJSONTree(b, "", decoded, 0)
+					return
+				}
+				s = string(data)
+			}
+		}
+		b.WriteString(html.EscapeString(s))
+	}
+}
+
+// writeJSONTree renders objects and arrays as nested details elements
+func writeJSONTree(b *strings.Builder, key string, value interface{}, depth int) {
+	label := ""
+	if key != "" {
+		label = "<span class=\"key\">" + html.EscapeString(key) + "</span>: "
+	}
+	open := ""
+	if depth < htmlTreeOpenDepth {
+		open = " open"
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(b, "<details class=\"tree\"%s><summary>%s{%d}</summary>\n", open, label, len(v))
+		for _, k := range keys {
+			writeJSONTree(b, k, v[k], depth+1)
+		}
+		b.WriteString("</details>\n")
+	case []interface{}:
+		fmt.Fprintf(b, "<details class=\"tree\"%s><summary>%s[%d]</summary>\n", open, label, len(v))
+		for i, item := range v {
+			writeJSONTree(b, fmt.Sprint(i), item, depth+1)
+		}
+		b.WriteString("</details>\n")
+	default:
+		s, ok := formatScalar(v)
+		if !ok {
+			s = fmt.Sprintf("%+v", v)
+		}
+		if str, isString := v.(string); isString {
+			s = fmt.Sprintf("%q", str)
+		} else if v == nil {
+			s = "null"
+		}
+		b.WriteString("<div class=\"tree\">")
+		b.WriteString(label)
+		b.WriteString(html.EscapeString(s))
+		b.WriteString("</div>\n")
+	}
+}
+
+// writeXMLTree renders element with children as details, text-only element as single line
+func writeXMLTree(b *strings.Builder, n *rawXMLNode, depth int) {
+	var tag strings.Builder
+	tag.WriteString("<span class=\"tag\">&lt;")
+	tag.WriteString(html.EscapeString(rawXMLName(n.name)))
+	tag.WriteString("</span>")
+	for _, attr := range n.attrs {
+		fmt.Fprintf(&tag, " <span class=\"attr\">%s</span>=&quot;%s&quot;", html.EscapeString(rawXMLName(attr.Name)), html.EscapeString(attr.Value))
+	}
+	tag.WriteString("<span class=\"tag\">&gt;</span>")
+	closing := "<span class=\"tag\">&lt;/" + html.EscapeString(rawXMLName(n.name)) + "&gt;</span>"
+	text := strings.TrimSpace(n.text.String())
+
+	if len(n.children) == 0 {
+		b.WriteString("<div class=\"tree\">")
+		b.WriteString(tag.String())
+		b.WriteString(html.EscapeString(text))
+		b.WriteString(closing)
+		b.WriteString("</div>\n")
+		return
+	}
+	open := ""
+	if depth < htmlTreeOpenDepth {
+		open = " open"
+	}
+	fmt.Fprintf(b, "<details class=\"tree\"%s><summary>%s</summary>\n", open, tag.String())
+	if text != "" {
+		b.WriteString("<div class=\"tree\">")
+		b.WriteString(html.EscapeString(text))
+		b.WriteString("</div>\n")
+	}
+	for _, child := range n.children {
+		writeXMLTree(b, child, depth+1)
+	}
+	b.WriteString(closing)
+	b.WriteString("</details>\n")
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// httpExchanges pairs http_request and http_response records by request_id and renders
+// combined exchange after response record, requests without response are reported at exit
+type httpExchanges struct {
+	formatter *recordFormatter
+	pending   map[string]*httpExchangeRequest
+	lastTime  time.Time
+	// jsonl writes reports of requests without response to json lines sink
+	jsonl bool
+}
+
+// httpExchangeRequest is a request waiting for response
+type httpExchangeRequest struct {
+	requestID string
+	line      int64
+	method    string
+	url       string
+	headers   interface{}
+	body      string
+	time      time.Time
+	hasTime   bool
+}
+
+func newHTTPExchanges(formatter *recordFormatter) *httpExchanges {
+	return &httpExchanges{
+		formatter: formatter,
+		pending:   make(map[string]*httpExchangeRequest),
+	}
+}
+
+// processRecord remembers request or writes exchange of response to writer
+func (e *httpExchanges) processRecord(rec *decodedRecord, writer *logWriter) {
+	record := rec.record
+	requestID := scalarField(record, "request_id")
+	t, hasTime := parseRecordTime(record.Get("time"))
+	if hasTime && t.After(e.lastTime) {
+		e.lastTime = t
+	}
+	if requestID == "" {
+		return
+	}
+	switch scalarField(record, "msg") {
+	case "http_request":
+		e.pending[requestID] = &httpExchangeRequest{
+			requestID: requestID,
+			line:      rec.number,
+			method:    scalarField(record, "method"),
+			url:       scalarField(record, "url"),
+			headers:   record.Get("headers"),
+			body:      scalarField(record, "body_string"),
+			time:      t,
+			hasTime:   hasTime,
+		}
+	case "http_response":
+		req, ok := e.pending[requestID]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "http response %s at line %d without request\n", requestID, rec.number)
+			return
+		}
+		delete(e.pending, requestID)
+		e.write(writer, req, rec, t, hasTime)
+	}
+}
+
+// write renders exchange with level of response, raised to warn for 4xx and error for 5xx status
+func (e *httpExchanges) write(writer *logWriter, req *httpExchangeRequest, rec *decodedRecord, t time.Time, hasTime bool) {
+	record := rec.record
+	status := scalarField(record, "status")
+	code := scalarField(record, "status_code")
+	if code != "" && !strings.HasPrefix(status, code) {
+		status = strings.TrimSpace(code + " " + status)
+	}
+	level := rec.level
+	switch {
+	case strings.HasPrefix(code, "5"):
+		level = logLevelError
+	case strings.HasPrefix(code, "4") && level < logLevelWarn:
+		level = logLevelWarn
+	}
+	latency := "unknown latency"
+	if req.hasTime && hasTime {
+		latency = t.Sub(req.time).String()
+	}
+
+	var b strings.Builder
+	title := fmt.Sprintf("http exchange %s: %s %s -> %s in %s", req.requestID, req.method, req.url, status, latency)
+	b.WriteString(title)
+	b.WriteByte('\n')
+	fmt.Fprintf(&b, "> %s %s\n", req.method, req.url)
+	writeCurlHeaders(&b, "> ", req.headers)
+	b.WriteString(">\n")
+	writeExchangeBody(&b, req.body, req.headers)
+	fmt.Fprintf(&b, "< %s\n", status)
+	headers := record.Get("headers")
+	writeCurlHeaders(&b, "< ", headers)
+	b.WriteString("<\n")
+	writeExchangeBody(&b, scalarField(record, "body_string"), headers)
+	b.WriteByte('\n')
+
+	out := e.formatter.newRecord()
+	plain := b.String()
+	colored := plain
+	if out.needColors {
+		colored = out.theme.level(level) + plain + resetStyle
+	}
+	out.writeBlock(level, colored, plain)
+	if out.compact != nil {
+		fields := []recordField{
+			{"level", logLevelName(level)},
+			{"msg", "http_exchange"},
+			{"request_id", req.requestID},
+			{"method", req.method},
+			{"url", req.url},
+			{"status", status},
+			{"latency", latency},
+		}
+		if hasTime {
+			fields = append([]recordField{{"time", t.UTC().Format(time.RFC3339Nano)}}, fields...)
+		}
+		out.writeCompact(level, fields)
+	}
+	if out.htmlReport {
+		out.level = level
+		out.requestID = req.requestID
+		out.title = title
+		out.html.WriteString("<pre>")
+		out.html.WriteString(html.EscapeString(plain))
+		out.html.WriteString("</pre>\n")
+	}
+	writer.WriteRecord(out)
+}
+
+// Close reports requests without response to writer as warnings
+func (e *httpExchanges) Close(writer *logWriter) {
+	requests := make([]*httpExchangeRequest, 0, len(e.pending))
+	for _, req := range e.pending {
+		requests = append(requests, req)
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].line < requests[j].line })
+	for _, req := range requests {
+		e.writeUnanswered(writer, req)
+	}
+}
+
+// writeUnanswered writes warning record about request without response
+func (e *httpExchanges) writeUnanswered(writer *logWriter, req *httpExchangeRequest) {
+	age := ""
+	if req.hasTime && !e.lastTime.IsZero() {
+		age = fmt.Sprintf(", %s before end of log", e.lastTime.Sub(req.time))
+	}
+	title := fmt.Sprintf("http request %s %s %s at line %d has no response%s, hung or timed out",
+		req.requestID, req.method, req.url, req.line, age)
+
+	out := e.formatter.newRecord()
+	plain := title + "\n\n"
+	colored := plain
+	if out.needColors {
+		colored = out.theme.level(logLevelWarn) + title + resetStyle + "\n\n"
+	}
+	out.writeBlock(logLevelWarn, colored, plain)
+	if out.htmlReport {
+		out.level = logLevelWarn
+		out.requestID = req.requestID
+		out.title = title
+		out.html.WriteString("<pre>")
+		out.html.WriteString(html.EscapeString(title))
+		out.html.WriteString("</pre>\n")
+	}
+	fields := []recordField{
+		{"level", logLevelName(logLevelWarn)},
+		{"msg", "http_request_without_response"},
+		{"request_id", req.requestID},
+		{"method", req.method},
+		{"url", req.url},
+		{"line", req.line},
+	}
+	if req.hasTime {
+		fields = append([]recordField{{"time", req.time.UTC().Format(time.RFC3339Nano)}}, fields...)
+	}
+	if out.compact != nil {
+		out.writeCompact(logLevelWarn, fields)
+	}
+	if e.jsonl {
+		writeJSONObject(&out.jsonl, fields)
+		out.jsonl.WriteByte('\n')
+	}
+	writer.WriteRecord(out)
+}
+
+// writeCurlHeaders writes headers sorted by name, one line per value, like curl -v
+func writeCurlHeaders(b *strings.Builder, prefix string, headers interface{}) {
+	m, ok := headers.(map[string]interface{})
+	if !ok {
+		return
+	}
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch values := m[name].(type) {
+		case []interface{}:
+			for _, v := range values {
+				fmt.Fprintf(b, "%s%s: %s\n", prefix, name, templateText(v))
+			}
+		default:
+			fmt.Fprintf(b, "%s%s: %s\n", prefix, name, templateText(values))
+		}
+	}
+}
+
+// writeExchangeBody writes xml and json bodies pretty printed, other bodies as is
+func writeExchangeBody(b *strings.Builder, body string, headers interface{}) {
+	if body == "" {
+		return
+	}
+	contentType := strings.ToLower(headerValue(headers, "Content-Type"))
+	switch {
+	case strings.Contains(contentType, "xml") || sniffXMLBody(body):
+		if rendered, err := renderXML(body, xmlRenderOptions.mode, xmlRenderOptions.maxDepth); err == nil {
+			body = rendered
+		}
+	case strings.Contains(contentType, "json") || sniffJSONBody(body):
+		d := json.NewDecoder(strings.NewReader(body))
+		d.UseNumber()
+		var data interface{}
+		if err := d.Decode(&data); err == nil {
+			if indented, err := json.MarshalIndent(data, "", "  "); err == nil {
+				body = string(indented)
+			}
+		}
+	}
+	b.WriteString(strings.TrimRight(body, "\r\n"))
+	b.WriteByte('\n')
+}
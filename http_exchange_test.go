@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// nopWriteCloser captures sink output of logWriter
+type nopWriteCloser struct {
+	bytes.Buffer
+}
+
+func (w *nopWriteCloser) Close() error {
+	return nil
+}
+
+// captureStdout returns what f writes to os.Stdout
+func captureStdout(t *testing.T, f func()) string {
+	file, err := ioutil.TempFile("", "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	stdout := os.Stdout
+	os.Stdout = file
+	defer func() { os.Stdout = stdout }()
+	f()
+	data, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestHTTPExchangeCompact(t *testing.T) {
+	compact, err := parseCompactTemplate("{level} {msg} {*}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &nopWriteCloser{}
+	writer := newWriter(false)
+	writer.decodedWriter = decoded
+	writer.needColors = false
+	writer.compact = compact
+	writer.compactWidth = &terminalWidth{}
+	e := newHTTPExchanges(&writer.recordFormatter)
+
+	stdout := captureStdout(t, func() {
+		for i, line := range []string{
+			`{"time":"2021-06-01T10:00:00Z","level":"info","msg":"http_request","request_id":"r1","method":"GET","url":"http://api/items"}`,
+			`{"time":"2021-06-01T10:00:01Z","level":"info","msg":"http_response","request_id":"r1","status_code":404,"body_string":"not\nfound"}`,
+			`{"level":"info","msg":"http_request","request_id":"r2","method":"POST","url":"http://api/hung"}`,
+		} {
+			record, err := parseRecord([]byte(line))
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.processRecord(&decodedRecord{record: record, number: int64(i + 1), level: logLevelInfo}, writer)
+		}
+		e.Close(writer)
+	})
+
+	want := "warn http_exchange time=2021-06-01T10:00:01Z request_id=r1 method=GET url=http://api/items status=404 latency=1s\n" +
+		"warn http_request_without_response request_id=r2 method=POST url=http://api/hung line=3\n"
+	if stdout != want {
+		t.Errorf("stdout:\n%s\nwant:\n%s", stdout, want)
+	}
+	if !strings.Contains(decoded.String(), "< 404\n") || !strings.Contains(decoded.String(), "has no response") {
+		t.Errorf("decoded sink has no exchange blocks:\n%s", decoded.String())
+	}
+}
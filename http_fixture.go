@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -27,6 +28,8 @@ type logLine struct {
 type bodyWithHeaders struct {
 	Headers    http.Header `json:"headers"`
 	BodyString string      `json:"body_string,omitempty"`
+	// BodyJSON is decoded json body, body_string is empty then
+	BodyJSON interface{} `json:"body_json,omitempty"`
 	//BodyData   interface{} `json:"body_data"`
 	BodyData interface{} `json:"-"`
 }
@@ -42,14 +45,27 @@ type requestResponse struct {
 	Status       string          `json:"status"`
 	Response     bodyWithHeaders `json:"response"`
 	SOAPResponse *winrmResponse  `json:"soap_response"`
+
+	// raw bodies and times are used by har, repro and sqlite exports
+	requestBody  string
+	responseBody string
+	requestTime  time.Time
+	responseTime time.Time
+	// protocol decodes request and its response, nil for plain http
+	protocol *fixtureProtocol
 }
 
 type commandResponse struct {
-	Command        string      `json:"command"`
-	Response       interface{} `json:"response,omitempty"`
-	ResponseString string      `json:"response_string,omitempty"`
-	ResponseStderr string      `json:"response_stderr,omitempty"`
-	ExitCode       int         `json:"exit_code,omitempty"`
+	RequestID          string              `json:"-"`
+	Command            string              `json:"command"`
+	Script             string              `json:"script,omitempty"`
+	Response           interface{}         `json:"response,omitempty"`
+	ResponseString     string              `json:"response_string,omitempty"`
+	ResponseStderr     string              `json:"response_stderr,omitempty"`
+	ResponseStderrText string              `json:"response_stderr_text,omitempty"`
+	ExitCode           int                 `json:"exit_code,omitempty"`
+	ResponseErrors     []clixmlErrorRecord `json:"response_errors,omitempty"`
+	ResponseProgress   []clixmlProgress    `json:"response_progress,omitempty"`
 	//ResponseMultiline []string    `json:"response_multiline,omitempty"`
 }
 
@@ -59,77 +75,126 @@ type fixture struct {
 	requestDict      map[string]*requestResponse
 	commandResponses []*commandResponse
 	currentCommand   string
+	currentScript    string
+	// normalizer makes saved files stable between runs, nil keeps them as captured
+	normalizer *fixtureNormalizer
 }
 
 func newFixture() *fixture {
 	return &fixture{
-		data:        []*requestResponse{},
-		requestDict: make(map[string]*requestResponse),
+		data:             []*requestResponse{},
+		requestDict:      make(map[string]*requestResponse),
+		commandResponses: []*commandResponse{},
 	}
 }
 
-func (f *fixture) processLine(line []byte) {
+// newLogLine fills logLine from parsed record, rejecting field types json.Unmarshal would reject
+func newLogLine(record *parsedRecord) (logLine, bool) {
 	var l logLine
-	err := json.Unmarshal(line, &l)
-	if err != nil {
-		return
+	ok := true
+	stringField := func(key string, dst *string) {
+		v, found := record.getFold(key)
+		if !found || v == nil {
+			return
+		}
+		s, isString := v.(string)
+		if !isString {
+			ok = false
+		}
+		*dst = s
 	}
-	if l.RequestID == "" {
+	stringField("request_id", &l.RequestID)
+	stringField("msg", &l.Message)
+	stringField("url", &l.Url)
+	stringField("method", &l.Method)
+	stringField("body_string", &l.BodyString)
+	stringField("status", &l.Status)
+
+	if v, found := record.getFold("status_code"); found && v != nil {
+		n, isNumber := v.(json.Number)
+		if !isNumber {
+			return l, false
+		}
+		code, err := strconv.Atoi(string(n))
+		if err != nil {
+			return l, false
+		}
+		l.StatusCode = code
+	}
+
+	if v, found := record.getFold("headers"); found && v != nil {
+		m, isMap := v.(map[string]interface{})
+		if !isMap {
+			return l, false
+		}
+		l.Headers = make(http.Header, len(m))
+		for k, values := range m {
+			if values == nil {
+				l.Headers[k] = nil
+				continue
+			}
+			list, isList := values.([]interface{})
+			if !isList {
+				return l, false
+			}
+			strs := make([]string, len(list))
+			for i, item := range list {
+				if item == nil {
+					continue
+				}
+				s, isString := item.(string)
+				if !isString {
+					return l, false
+				}
+				strs[i] = s
+			}
+			l.Headers[k] = strs
+		}
+	}
+	return l, ok
+}
+
+// processRecord collects request and response bodies from record decoded by main loop,
+// protocol specific data is decoded by fixture protocol selected by request
+func (f *fixture) processRecord(record *parsedRecord) {
+	l, ok := newLogLine(record)
+	if !ok {
 		return
 	}
-	if l.BodyString == "" {
+	if l.RequestID == "" {
 		return
 	}
+	t, _ := parseRecordTime(record.Get("time"))
 	switch l.Message {
 	case "http_request":
-		bodyString := ""
-		n, err := decodeXML(l.BodyString)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error parse %s xml request body in %+v\n", err, l)
-			bodyString = l.BodyString
-		}
-		r, err := parseRequest(l.BodyString)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error parse %s winrm soap request body in %+v\n", err, l)
-		}
-
 		pair := &requestResponse{
-			RequestID: l.RequestID,
-			Url:       l.Url,
-			Method:    l.Method,
-			Request: bodyWithHeaders{
-				Headers:    l.Headers,
-				BodyString: bodyString,
-				BodyData:   n,
-			},
-			SOAPRequest: r,
+			RequestID:   l.RequestID,
+			Url:         l.Url,
+			Method:      l.Method,
+			Request:     newFixtureBody(&l, false),
+			requestBody: l.BodyString,
+			requestTime: t,
+			protocol:    findFixtureProtocol(&l),
 		}
 		f.requestDict[l.RequestID] = pair
 		f.data = append(f.data, pair)
-		f.processRequest(r)
+		if pair.protocol != nil {
+			pair.protocol.request(f, pair, &l)
+		}
 	case "http_response":
 		found, ok := f.requestDict[l.RequestID]
 		if !ok {
-			fmt.Fprintf(os.Stderr, "not found request %s for %+v", l.RequestID, l)
+			fmt.Fprintf(os.Stderr, "not found request %s for %+v\n", l.RequestID, l)
 			return
 		}
-		n, err := decodeXML(l.BodyString)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "invalid xml response body in %+v\n", l)
-		}
-		r, err := parseResponse(l.BodyString)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error parse %s  winrm soap response body in %+v\n", err, l)
-		}
-		found.SOAPResponse = r
 		found.Status = l.Status
 		found.StatusCode = l.StatusCode
-		found.Response = bodyWithHeaders{
-			Headers:    l.Headers,
-			BodyString: l.BodyString,
-			BodyData:   n,
+		found.Response = newFixtureBody(&l, true)
+		found.responseBody = l.BodyString
+		found.responseTime = t
+		if found.protocol != nil {
+			found.protocol.response(f, found, &l)
 		}
-		f.processResponse(r)
 	}
 }
 
@@ -139,13 +204,15 @@ func (f *fixture) processRequest(r *winrmRequest) {
 			fmt.Fprintf(os.Stderr, "Incorrect http fixture state on %s: %s\n", r.CommandKey, f.currentCommand)
 		}
 		f.currentCommand = r.CommandKey
+		f.currentScript = r.Script
 	}
 	if r.Action == "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Signal" {
 		f.currentCommand = ""
+		f.currentScript = ""
 	}
 }
 
-func (f *fixture) processResponse(r *winrmResponse) {
+func (f *fixture) processResponse(requestID string, r *winrmResponse) {
 	if r.Action == "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/ReceiveResponse" {
 		if f.currentCommand == "" {
 			fmt.Fprintf(os.Stderr, "Incorrect http fixture state on %s\n", r.Action)
@@ -159,30 +226,38 @@ func (f *fixture) processResponse(r *winrmResponse) {
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Invalid exit code %s\n", r.ExitCode)
 		}
-		f.commandResponses = append(f.commandResponses, &commandResponse{
+		cr := &commandResponse{
+			RequestID:      requestID,
 			Command:        f.currentCommand,
+			Script:         f.currentScript,
 			Response:       r.CommandStdoutJSON,
 			ResponseString: responseString,
 			ResponseStderr: r.CommandStderr,
 			ExitCode:       exitCode,
 			//ResponseMultiline: strings.Split(responseString, "\n"),
-		})
+		}
+		if r.CommandStderrCLIXML != nil {
+			cr.ResponseStderrText = r.CommandStderrText
+			cr.ResponseErrors = r.CommandStderrCLIXML.ErrorRecords
+			cr.ResponseProgress = r.CommandStderrCLIXML.Progress
+		}
+		f.commandResponses = append(f.commandResponses, cr)
 	}
 }
 
 func (f *fixture) SaveToFile(filename string) error {
-	data, err := json.MarshalIndent(f.data, "", "  ")
+	data, err := f.marshal(f.data)
 	if err != nil {
-		return errors.Wrap(err, "MarshalIndent failed")
+		return err
 	}
 	err = ioutil.WriteFile(filename, data, 0660)
 	if err != nil {
 		return errors.Wrap(err, "WriteFile failed")
 	}
 
-	data, err = json.MarshalIndent(f.commandResponses, "", "  ")
+	data, err = f.marshal(f.commandResponses)
 	if err != nil {
-		return errors.Wrap(err, "MarshalIndent failed")
+		return err
 	}
 	err = ioutil.WriteFile(filename+"_responses.json", data, 0660)
 	if err != nil {
@@ -191,3 +266,18 @@ func (f *fixture) SaveToFile(filename string) error {
 
 	return nil
 }
+
+// marshal encodes fixture file, normalized if normalizer is set
+func (f *fixture) marshal(v interface{}) ([]byte, error) {
+	if f.normalizer != nil {
+		var err error
+		if v, err = f.normalizer.normalize(v); err != nil {
+			return nil, err
+		}
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "MarshalIndent failed")
+	}
+	return data, nil
+}
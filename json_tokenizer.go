@@ -0,0 +1,435 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// recordField is a top-level field of decoded log line
+type recordField struct {
+	key   string
+	value interface{}
+}
+
+// parsedRecord is a decoded log line with fields in input order,
+// nested objects and arrays are decoded as map[string]interface{} and []interface{}
+type parsedRecord struct {
+	fields []recordField
+}
+
+// errFastPath is returned by tokenizer for input it does not handle, e.g. invalid UTF-8
+var errFastPath = errors.New("fast path not applicable")
+
+// parseRecord decodes log line with tokenizer, falling back to encoding/json
+// for invalid input to keep its error messages and behaviour
+func parseRecord(line []byte) (*parsedRecord, error) {
+	t := jsonTokenizer{data: line}
+	if r, err := t.parseRecord(); err == nil {
+		return r, nil
+	}
+	linedata, err := unmarshal(line)
+	if err != nil {
+		return nil, err
+	}
+	r := &parsedRecord{fields: make([]recordField, 0, len(linedata))}
+	for k, v := range linedata {
+		r.fields = append(r.fields, recordField{k, v})
+	}
+	return r, nil
+}
+
+// Get returns field value or nil
+func (r *parsedRecord) Get(key string) interface{} {
+	for i := range r.fields {
+		if r.fields[i].key == key {
+			return r.fields[i].value
+		}
+	}
+	return nil
+}
+
+// getFold returns field value matching key case-insensitively, exact match preferred,
+// the same way as encoding/json matches struct fields
+func (r *parsedRecord) getFold(key string) (interface{}, bool) {
+	var found interface{}
+	ok := false
+	for i := range r.fields {
+		if r.fields[i].key == key {
+			return r.fields[i].value, true
+		}
+		if !ok && strings.EqualFold(r.fields[i].key, key) {
+			found = r.fields[i].value
+			ok = true
+		}
+	}
+	return found, ok
+}
+
+// GetString returns string field value or empty string
+func (r *parsedRecord) GetString(key string) string {
+	s, _ := r.Get(key).(string)
+	return s
+}
+
+// Set replaces field value or appends new field
+func (r *parsedRecord) Set(key string, value interface{}) {
+	for i := range r.fields {
+		if r.fields[i].key == key {
+			r.fields[i].value = value
+			return
+		}
+	}
+	r.fields = append(r.fields, recordField{key, value})
+}
+
+// Map returns fields as map
+func (r *parsedRecord) Map() map[string]interface{} {
+	m := make(map[string]interface{}, len(r.fields))
+	for _, f := range r.fields {
+		m[f.key] = f.value
+	}
+	return m
+}
+
+// formatScalar formats decoded JSON scalar as fmt "%+v" does, without reflection
+func formatScalar(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return string(v), true
+	case bool:
+		if v {
+			return "true", true
+		}
+		return "false", true
+	case nil:
+		return "<nil>", true
+	default:
+		return "", false
+	}
+}
+
+// jsonMaxDepth is nesting limit of encoding/json, deeper input is left to unmarshal
+const jsonMaxDepth = 10000
+
+// jsonTokenizer is a single-pass decoder of valid UTF-8 JSON object
+type jsonTokenizer struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+func (t *jsonTokenizer) parseRecord() (*parsedRecord, error) {
+	t.skipSpace()
+	if !t.consume('{') {
+		return nil, errFastPath
+	}
+	r := &parsedRecord{fields: make([]recordField, 0, 16)}
+	t.skipSpace()
+	if t.consume('}') {
+		return r, nil
+	}
+	for {
+		t.skipSpace()
+		key, err := t.parseString()
+		if err != nil {
+			return nil, err
+		}
+		t.skipSpace()
+		if !t.consume(':') {
+			return nil, errFastPath
+		}
+		value, err := t.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		r.Set(key, value)
+		t.skipSpace()
+		if t.consume(',') {
+			continue
+		}
+		if t.consume('}') {
+			return r, nil
+		}
+		return nil, errFastPath
+	}
+}
+
+func (t *jsonTokenizer) skipSpace() {
+	for t.pos < len(t.data) {
+		switch t.data[t.pos] {
+		case ' ', '\t', '\r', '\n':
+			t.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (t *jsonTokenizer) consume(c byte) bool {
+	if t.pos < len(t.data) && t.data[t.pos] == c {
+		t.pos++
+		return true
+	}
+	return false
+}
+
+func (t *jsonTokenizer) consumeLiteral(literal string) bool {
+	if len(t.data)-t.pos >= len(literal) && string(t.data[t.pos:t.pos+len(literal)]) == literal {
+		t.pos += len(literal)
+		return true
+	}
+	return false
+}
+
+func (t *jsonTokenizer) parseValue() (interface{}, error) {
+	t.skipSpace()
+	if t.pos >= len(t.data) {
+		return nil, errFastPath
+	}
+	switch c := t.data[t.pos]; {
+	case c == '"':
+		return t.parseString()
+	case c == '{':
+		return t.parseObject()
+	case c == '[':
+		return t.parseArray()
+	case c == 't' && t.consumeLiteral("true"):
+		return true, nil
+	case c == 'f' && t.consumeLiteral("false"):
+		return false, nil
+	case c == 'n' && t.consumeLiteral("null"):
+		return nil, nil
+	case c == '-' || (c >= '0' && c <= '9'):
+		return t.parseNumber()
+	default:
+		return nil, errFastPath
+	}
+}
+
+func (t *jsonTokenizer) parseObject() (interface{}, error) {
+	t.pos++
+	t.depth++
+	defer func() { t.depth-- }()
+	if t.depth >= jsonMaxDepth {
+		return nil, errFastPath
+	}
+	m := make(map[string]interface{})
+	t.skipSpace()
+	if t.consume('}') {
+		return m, nil
+	}
+	for {
+		t.skipSpace()
+		key, err := t.parseString()
+		if err != nil {
+			return nil, err
+		}
+		t.skipSpace()
+		if !t.consume(':') {
+			return nil, errFastPath
+		}
+		value, err := t.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+		t.skipSpace()
+		if t.consume(',') {
+			continue
+		}
+		if t.consume('}') {
+			return m, nil
+		}
+		return nil, errFastPath
+	}
+}
+
+func (t *jsonTokenizer) parseArray() (interface{}, error) {
+	t.pos++
+	t.depth++
+	defer func() { t.depth-- }()
+	if t.depth >= jsonMaxDepth {
+		return nil, errFastPath
+	}
+	a := make([]interface{}, 0)
+	t.skipSpace()
+	if t.consume(']') {
+		return a, nil
+	}
+	for {
+		value, err := t.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, value)
+		t.skipSpace()
+		if t.consume(',') {
+			continue
+		}
+		if t.consume(']') {
+			return a, nil
+		}
+		return nil, errFastPath
+	}
+}
+
+// parseNumber validates JSON number grammar and keeps it as json.Number like UseNumber does
+func (t *jsonTokenizer) parseNumber() (interface{}, error) {
+	start := t.pos
+	t.consume('-')
+	switch {
+	case t.consume('0'):
+	case t.pos < len(t.data) && t.data[t.pos] >= '1' && t.data[t.pos] <= '9':
+		t.skipDigits()
+	default:
+		return nil, errFastPath
+	}
+	if t.consume('.') {
+		if t.skipDigits() == 0 {
+			return nil, errFastPath
+		}
+	}
+	if t.consume('e') || t.consume('E') {
+		if !t.consume('+') {
+			t.consume('-')
+		}
+		if t.skipDigits() == 0 {
+			return nil, errFastPath
+		}
+	}
+	return json.Number(t.data[start:t.pos]), nil
+}
+
+func (t *jsonTokenizer) skipDigits() int {
+	start := t.pos
+	for t.pos < len(t.data) && t.data[t.pos] >= '0' && t.data[t.pos] <= '9' {
+		t.pos++
+	}
+	return t.pos - start
+}
+
+func (t *jsonTokenizer) parseString() (string, error) {
+	if !t.consume('"') {
+		return "", errFastPath
+	}
+	start := t.pos
+	for t.pos < len(t.data) {
+		c := t.data[t.pos]
+		switch {
+		case c == '"':
+			s := t.data[start:t.pos]
+			t.pos++
+			return string(s), nil
+		case c == '\\':
+			return t.parseEscapedString(start)
+		case c < 0x20:
+			return "", errFastPath
+		case c < utf8.RuneSelf:
+			t.pos++
+		default:
+			r, size := utf8.DecodeRune(t.data[t.pos:])
+			if r == utf8.RuneError && size == 1 {
+				return "", errFastPath
+			}
+			t.pos += size
+		}
+	}
+	return "", errFastPath
+}
+
+// parseEscapedString continues string decoding from start when escape is found
+func (t *jsonTokenizer) parseEscapedString(start int) (string, error) {
+	b := make([]byte, 0, t.pos-start+16)
+	b = append(b, t.data[start:t.pos]...)
+	for t.pos < len(t.data) {
+		c := t.data[t.pos]
+		switch {
+		case c == '"':
+			t.pos++
+			return string(b), nil
+		case c == '\\':
+			t.pos++
+			if t.pos >= len(t.data) {
+				return "", errFastPath
+			}
+			e := t.data[t.pos]
+			t.pos++
+			switch e {
+			case '"', '\\', '/':
+				b = append(b, e)
+			case 'b':
+				b = append(b, '\b')
+			case 'f':
+				b = append(b, '\f')
+			case 'n':
+				b = append(b, '\n')
+			case 'r':
+				b = append(b, '\r')
+			case 't':
+				b = append(b, '\t')
+			case 'u':
+				r, ok := t.parseUnicodeEscape()
+				if !ok {
+					return "", errFastPath
+				}
+				b = append(b, string(r)...)
+			default:
+				return "", errFastPath
+			}
+		case c < 0x20:
+			return "", errFastPath
+		case c < utf8.RuneSelf:
+			b = append(b, c)
+			t.pos++
+		default:
+			r, size := utf8.DecodeRune(t.data[t.pos:])
+			if r == utf8.RuneError && size == 1 {
+				return "", errFastPath
+			}
+			b = append(b, t.data[t.pos:t.pos+size]...)
+			t.pos += size
+		}
+	}
+	return "", errFastPath
+}
+
+// parseUnicodeEscape decodes \uXXXX after \u, combining surrogate pairs
+func (t *jsonTokenizer) parseUnicodeEscape() (rune, bool) {
+	r, ok := t.hex4()
+	if !ok {
+		return 0, false
+	}
+	if !utf16.IsSurrogate(r) {
+		return r, true
+	}
+	save := t.pos
+	if t.consume('\\') && t.consume('u') {
+		r2, ok := t.hex4()
+		if ok {
+			if combined := utf16.DecodeRune(r, r2); combined != utf8.RuneError {
+				return combined, true
+			}
+		}
+	}
+	t.pos = save
+	return utf8.RuneError, true
+}
+
+func (t *jsonTokenizer) hex4() (rune, bool) {
+	if len(t.data)-t.pos < 4 {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(string(t.data[t.pos:t.pos+4]), 16, 32)
+	if err != nil {
+		return 0, false
+	}
+	t.pos += 4
+	return rune(v), true
+}
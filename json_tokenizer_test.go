@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRecordMatchesEncodingJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		// fast is true when tokenizer decodes input without encoding/json fallback
+		fast bool
+	}{
+		{"empty object", `{}`, true},
+		{"spaces", " \t{ \"a\" : 1 , \"b\":\"x\" }\r\n", true},
+		{"scalars", `{"s":"x","i":-12,"f":0.5e-3,"E":1E+2,"t":true,"f2":false,"n":null}`, true},
+		{"nested", `{"o":{"a":[1,{"b":[]},{}],"c":{}},"a":[[],[null]]}`, true},
+		{"escapes", `{"s":"q\"b\\s\/b\bf\fn\nr\rt\t"}`, true},
+		{"unicode escape", `{"s":"\u00e9\u4e2d\u0000"}`, true},
+		{"surrogate pair", `{"s":"\ud83d\ude00"}`, true},
+		{"lone high surrogate", `{"s":"a\ud83db"}`, true},
+		{"high surrogate before other escape", `{"s":"\ud83d\u0041"}`, true},
+		{"lone low surrogate", `{"s":"\ude00"}`, true},
+		{"utf8", `{"ключ":"значение 😀"}`, true},
+		{"large numbers", `{"big":123456789012345678901234567890,"small":-0.000000000000000000001,"exp":1e400}`, true},
+		{"duplicate keys", `{"a":1,"b":2,"a":3}`, true},
+		{"duplicate nested keys", `{"o":{"a":1,"a":"x"}}`, true},
+		{"trailing garbage ignored", `{"a":1} garbage`, true},
+		{"null", `null`, false},
+		{"array", `[1,2]`, false},
+		{"string", `"a"`, false},
+		{"number", `12`, false},
+		{"empty", ``, false},
+		{"truncated object", `{"a":1`, false},
+		{"truncated string", `{"a":"xy`, false},
+		{"truncated escape", `{"a":"x\`, false},
+		{"truncated unicode escape", `{"a":"\u12`, false},
+		{"truncated literal", `{"a":tru`, false},
+		{"truncated array", `{"a":[1,`, false},
+		{"missing colon", `{"a" 1}`, false},
+		{"trailing comma", `{"a":1,}`, false},
+		{"leading zero", `{"a":01}`, false},
+		{"bad exponent", `{"a":1e}`, false},
+		{"bad fraction", `{"a":1.}`, false},
+		{"plus sign", `{"a":+1}`, false},
+		{"bad escape", `{"a":"\x"}`, false},
+		{"control character", "{\"a\":\"x\ty\"}", false},
+		{"invalid utf8", "{\"a\":\"\xff\"}", false},
+		{"invalid utf8 after escape", "{\"a\":\"\\n\xff\"}", false},
+		{"unquoted key", `{a:1}`, false},
+		{"max depth", `{"a":` + strings.Repeat("[", jsonMaxDepth-1) + strings.Repeat("]", jsonMaxDepth-1) + `}`, true},
+		{"exceeded max depth", `{"a":` + strings.Repeat(`{"b":`, jsonMaxDepth) + "1" + strings.Repeat("}", jsonMaxDepth) + `}`, false},
+		// recursion stops at max depth instead of overflowing the stack
+		{"deep unterminated", `{"a":` + strings.Repeat("[", 20<<20), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, wantErr := unmarshal([]byte(tt.input))
+			got, err := parseRecord([]byte(tt.input))
+			if (err != nil) != (wantErr != nil) {
+				t.Fatalf("error %v, encoding/json error %v", err, wantErr)
+			}
+			if err != nil {
+				if err.Error() != wantErr.Error() {
+					t.Errorf("error %q, encoding/json error %q", err, wantErr)
+				}
+				return
+			}
+			if gotMap := got.Map(); len(gotMap) != 0 || len(want) != 0 {
+				if !reflect.DeepEqual(gotMap, want) {
+					t.Errorf("got %#v, encoding/json %#v", gotMap, want)
+				}
+			}
+			tokenizer := jsonTokenizer{data: []byte(tt.input)}
+			_, fastErr := tokenizer.parseRecord()
+			if (fastErr == nil) != tt.fast {
+				t.Errorf("tokenizer error %v, fast path expected %t", fastErr, tt.fast)
+			}
+		})
+	}
+}
+
+func TestParseRecordKeepsFieldOrder(t *testing.T) {
+	r, err := parseRecord([]byte(`{"z":1,"a":{"y":2},"m":3,"z":4}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, f := range r.fields {
+		keys = append(keys, f.key)
+	}
+	if !reflect.DeepEqual(keys, []string{"z", "a", "m"}) {
+		t.Errorf("keys %v", keys)
+	}
+	if r.Get("z") != json.Number("4") {
+		t.Errorf("duplicate key value %#v, last value expected", r.Get("z"))
+	}
+}
+
+func TestFormatScalar(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+		ok    bool
+	}{
+		{"x", "x", true},
+		{json.Number("1.50"), "1.50", true},
+		{true, "true", true},
+		{false, "false", true},
+		{nil, "<nil>", true},
+		{map[string]interface{}{}, "", false},
+		{[]interface{}{}, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := formatScalar(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("formatScalar(%#v) = %q, %t, want %q, %t", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
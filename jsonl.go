@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// redactedValue replaces values of redacted fields in json lines output
+const redactedValue = "[REDACTED]"
+
+// jsonlOptions are normalization settings of json lines sink
+type jsonlOptions struct {
+	redactFields map[string]struct{}
+}
+
+// parseRedactFields splits comma separated list of field names, matched case-insensitively
+func parseRedactFields(s string) map[string]struct{} {
+	m := make(map[string]struct{})
+	for _, key := range strings.Split(s, ",") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			m[strings.ToLower(key)] = struct{}{}
+		}
+	}
+	return m
+}
+
+// writeJSONL writes normalized record as a single json line: time in UTC RFC3339,
+// canonical level, redacted values, decoded bodies and winrm fields
+func (d *recordDecoder) writeJSONL(b *bytes.Buffer, rec *decodedRecord) {
+	record := rec.record
+	fields := make([]recordField, 0, len(record.fields)+4)
+	skip := func(key string, value interface{}) bool {
+		if _, skip := d.skipFields[key]; skip {
+			return true
+		}
+		return d.skipEmpty && isEmpty(value)
+	}
+	add := func(key string, value interface{}) {
+		if !skip(key, value) {
+			fields = append(fields, recordField{key, d.jsonl.redact(key, value)})
+		}
+	}
+
+	for _, f := range record.fields {
+		switch f.key {
+		case "time":
+			add(f.key, normalizeTime(f.value))
+			continue
+		case "level":
+			canonical := logLevelName(rec.level)
+			add(f.key, canonical)
+			if s, _ := f.value.(string); s != canonical {
+				add("level_raw", f.value)
+			}
+			continue
+		}
+		if _, ok := d.bodyFields[f.key]; !ok {
+			add(f.key, f.value)
+			continue
+		}
+		var decoded bodyForms
+		showBody(func(level logLevel, name string, value interface{}) {}, func(level logLevel, name string, value interface{}) {
+			if !skip(name, value) {
+				decoded.add(name, d.jsonl, value)
+			}
+		}, rec.level, f.key, f.value, record.Get("headers"))
+		// raw body keeps values redacted in its decoded forms
+		if decoded.redacted(f.key) {
+			add(f.key, redactedValue)
+		} else {
+			add(f.key, f.value)
+		}
+		fields = append(fields, decoded.fields(d.jsonl)...)
+		body, _ := f.value.(string)
+		if winrm := decodeWinRMFields(record.GetString("msg"), body); winrm != nil {
+			add(f.key+"_winrm", winrm)
+		}
+	}
+
+	writeJSONObject(b, fields)
+	b.WriteByte('\n')
+}
+
+// bodyForms are redacted decoded forms of body field
+type bodyForms []bodyForm
+
+type bodyForm struct {
+	key      string
+	value    interface{}
+	redacted bool
+}
+
+func (forms *bodyForms) add(key string, o *jsonlOptions, value interface{}) {
+	redacted, changed := o.redactValue(key, value)
+	*forms = append(*forms, bodyForm{key, redacted, changed})
+}
+
+// redacted checks if decoded forms of body or of its nested bodies had redacted values
+func (forms bodyForms) redacted(name string) bool {
+	for _, f := range forms {
+		if f.redacted && (f.key == name || strings.HasPrefix(f.key, name+"_")) {
+			return true
+		}
+	}
+	return false
+}
+
+// fields returns decoded forms, raw bodies of multipart parts are redacted like raw body
+func (forms bodyForms) fields(o *jsonlOptions) []recordField {
+	fields := make([]recordField, 0, len(forms))
+	for _, f := range forms {
+		if parts, ok := f.value.([]multipartPart); ok {
+			name := strings.TrimSuffix(f.key, "_multipart")
+			redactedParts := make([]multipartPart, len(parts))
+			for i, part := range parts {
+				if forms.redacted(fmt.Sprintf("%s_part%d", name, i+1)) {
+					part.Body = redactedValue
+				}
+				redactedParts[i] = part
+			}
+			f.value = redactedParts
+		}
+		fields = append(fields, recordField{f.key, f.value})
+	}
+	return fields
+}
+
+// writeJSONObject writes fields as json object keeping their order, html characters are not escaped
+func writeJSONObject(b *bytes.Buffer, fields []recordField) {
+	b.WriteByte('{')
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if err := enc.Encode(f.key); err != nil {
+			fmt.Fprintf(os.Stderr, "json encode error %s\n", err)
+			continue
+		}
+		b.Truncate(b.Len() - 1)
+		b.WriteByte(':')
+		if err := enc.Encode(f.value); err != nil {
+			fmt.Fprintf(os.Stderr, "json encode %s error %s\n", f.key, err)
+			b.WriteString("null\n")
+		}
+		b.Truncate(b.Len() - 1)
+	}
+	b.WriteByte('}')
+}
+
+// redact replaces value of redacted field, nested objects are redacted recursively
+func (o *jsonlOptions) redact(key string, value interface{}) interface{} {
+	v, _ := o.redactValue(key, value)
+	return v
+}
+
+// redactValue is redact reporting if any value was replaced
+func (o *jsonlOptions) redactValue(key string, value interface{}) (interface{}, bool) {
+	if len(o.redactFields) == 0 {
+		return value, false
+	}
+	if _, ok := o.redactFields[strings.ToLower(key)]; ok {
+		return redactedValue, true
+	}
+	redacted := false
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			var changed bool
+			m[k], changed = o.redactValue(k, item)
+			redacted = redacted || changed
+		}
+		return m, redacted
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, item := range v {
+			var changed bool
+			a[i], changed = o.redactValue("", item)
+			redacted = redacted || changed
+		}
+		return a, redacted
+	default:
+		return value, false
+	}
+}
+
+// normalizeTime converts RFC3339 string or unix seconds to UTC RFC3339 with nanoseconds,
+// unknown formats are kept as is
+func normalizeTime(value interface{}) interface{} {
+	t, ok := parseRecordTime(value)
+	if !ok {
+		return value
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// parseRecordTime parses RFC 3339 string or unix seconds number
+func parseRecordTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return t, true
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// decodeWinRMFields parses winrm soap request or response body of http log record
+func decodeWinRMFields(msg, body string) interface{} {
+	if !strings.Contains(body, "/wsman") || !sniffXMLBody(body) {
+		return nil
+	}
+	switch msg {
+	case "http_request":
+		r, err := parseRequest(body)
+		if err != nil {
+			return nil
+		}
+		return r
+	case "http_response":
+		r, err := decodeResponse(body)
+		if err != nil {
+			return nil
+		}
+		return r
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func writeTestJSONL(t *testing.T, d *recordDecoder, line string) []byte {
+	record, err := parseRecord([]byte(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	d.writeJSONL(&b, &decodedRecord{record: record, level: parseLogLevel(record.GetString("level"))})
+	return b.Bytes()
+}
+
+func jsonString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+func TestWriteJSONLRedactsBodies(t *testing.T) {
+	const secret = "s3cr3t p@ss&word"
+	d := &recordDecoder{
+		bodyFields: map[string]struct{}{"body_string": {}},
+		jsonl:      &jsonlOptions{redactFields: parseRedactFields("password,authorization")},
+	}
+	multipart := "--XyZ\r\nContent-Disposition: form-data; name=\"creds\"\r\nContent-Type: application/json\r\n\r\n" +
+		`{"user":"u","password":"` + secret + `"}` + "\r\n--XyZ\r\nContent-Disposition: form-data; name=\"note\"\r\n\r\nvisible note\r\n--XyZ--\r\n"
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		// visible is a part of body that is not redacted
+		visible string
+	}{
+		{"json", "application/json", `{"user":"u","password":"` + secret + `"}`, `"user":"u"`},
+		{"nested json", "application/json", `{"login":{"password":"` + secret + `"}}`, `"login":{`},
+		{"form", "application/x-www-form-urlencoded", "user=u&password=" + url.QueryEscape(secret), `"user":"u"`},
+		{"multipart", "multipart/form-data; boundary=XyZ", multipart, "visible note"},
+		{"base64 json", "", base64.StdEncoding.EncodeToString([]byte(`{"password":"` + secret + `","user":"u"}`)), `"user":"u"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := "{}"
+			if tt.contentType != "" {
+				headers = `{"Content-Type":["` + tt.contentType + `"]}`
+			}
+			line := writeTestJSONL(t, d, `{"level":"debug","msg":"http_request","headers":`+headers+`,"body_string":`+jsonString(tt.body)+`}`)
+			for _, leaked := range []string{secret, url.QueryEscape(secret), base64.StdEncoding.EncodeToString([]byte(secret))[:12]} {
+				if strings.Contains(string(line), leaked) {
+					t.Errorf("redacted value %q is written:\n%s", leaked, line)
+				}
+			}
+			if !strings.Contains(string(line), tt.visible) {
+				t.Errorf("decoded form without %q:\n%s", tt.visible, line)
+			}
+			if !json.Valid(line) {
+				t.Errorf("invalid json line:\n%s", line)
+			}
+		})
+	}
+}
+
+func TestWriteJSONLKeepsBodyWithoutRedactedValues(t *testing.T) {
+	d := &recordDecoder{
+		bodyFields: map[string]struct{}{"body_string": {}},
+		jsonl:      &jsonlOptions{redactFields: parseRedactFields("password")},
+	}
+	line := writeTestJSONL(t, d, `{"time":1622541600,"level":"information","msg":"http_response","headers":{"Content-Type":["application/json"]},"body_string":"{\"id\":1}"}`)
+	want := `{"time":"2021-06-01T10:00:00Z","level":"warn","level_raw":"information","msg":"http_response","headers":{"Content-Type":["application/json"]},"body_string":"{\"id\":1}","body_string_json":{"id":1}}` + "\n"
+	if string(line) != want {
+		t.Errorf("got\n%s\nwant\n%s", line, want)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"io"
+)
+
+// lineReaderBufferSize is a size of read buffer, lines are accumulated in chunks of this size
+const lineReaderBufferSize = 262144
+
+// inputLine is a line of input with its position, lines longer than limit are truncated
+type inputLine struct {
+	data      []byte
+	number    int64
+	offset    int64
+	size      int64
+	truncated bool
+}
+
+// lineReader reads lines like bufio.Scanner with ScanLines, but does not stop
+// on lines longer than maxLine: they are truncated and reading continues
+type lineReader struct {
+	r       *bufio.Reader
+	maxLine int
+	offset  int64
+	number  int64
+	line    inputLine
+	err     error
+}
+
+func newLineReader(r io.Reader, maxLine int) *lineReader {
+	return &lineReader{
+		r:       bufio.NewReaderSize(r, lineReaderBufferSize),
+		maxLine: maxLine,
+	}
+}
+
+// Next reads next line, returns false at the end of input or on read error
+func (l *lineReader) Next() bool {
+	l.number++
+	l.line = inputLine{data: l.line.data[:0], number: l.number, offset: l.offset}
+	for {
+		chunk, err := l.r.ReadSlice('\n')
+		l.offset += int64(len(chunk))
+		l.line.size += int64(len(chunk))
+		keep := chunk
+		if err == nil {
+			keep = chunk[:len(chunk)-1]
+		}
+		if room := l.maxLine - len(l.line.data); len(keep) > room && l.maxLine > 0 {
+			if room > 0 {
+				l.line.data = append(l.line.data, keep[:room]...)
+			}
+			l.line.truncated = true
+		} else {
+			l.line.data = append(l.line.data, keep...)
+		}
+
+		switch err {
+		case nil:
+			l.dropCR()
+			return true
+		case bufio.ErrBufferFull:
+			continue
+		case io.EOF:
+			l.dropCR()
+			return l.line.size > 0
+		default:
+			l.err = err
+			return false
+		}
+	}
+}
+
+func (l *lineReader) dropCR() {
+	if n := len(l.line.data); n > 0 && !l.line.truncated && l.line.data[n-1] == '\r' {
+		l.line.data = l.line.data[:n-1]
+	}
+}
+
+// Line returns current line, its data is valid until next call of Next
+func (l *lineReader) Line() *inputLine {
+	return &l.line
+}
+
+// Err returns read error other than io.EOF
+func (l *lineReader) Err() error {
+	return l.err
+}
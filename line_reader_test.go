@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+type testLine struct {
+	data      string
+	number    int64
+	offset    int64
+	size      int64
+	truncated bool
+}
+
+func readTestLines(t *testing.T, input string, maxLine int) []testLine {
+	r := newLineReader(strings.NewReader(input), maxLine)
+	var lines []testLine
+	for r.Next() {
+		l := r.Line()
+		lines = append(lines, testLine{string(l.data), l.number, l.offset, l.size, l.truncated})
+	}
+	if err := r.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func compareTestLines(t *testing.T, got, want []testLine) {
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines %v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestLineReaderOffsets(t *testing.T) {
+	got := readTestLines(t, "first\n\nthird line\nlast", 0)
+	compareTestLines(t, got, []testLine{
+		{"first", 1, 0, 6, false},
+		{"", 2, 6, 1, false},
+		{"third line", 3, 7, 11, false},
+		{"last", 4, 18, 4, false},
+	})
+	if got := readTestLines(t, "", 0); len(got) != 0 {
+		t.Errorf("empty input: %v", got)
+	}
+}
+
+func TestLineReaderCRLF(t *testing.T) {
+	got := readTestLines(t, "a\r\nb\r\r\n\r\nc\r", 0)
+	compareTestLines(t, got, []testLine{
+		{"a", 1, 0, 3, false},
+		{"b\r", 2, 3, 4, false},
+		{"", 3, 7, 2, false},
+		{"c", 4, 9, 2, false},
+	})
+}
+
+func TestLineReaderTruncates(t *testing.T) {
+	got := readTestLines(t, "12345\n123456\n1234567890\r\nok\n", 6)
+	compareTestLines(t, got, []testLine{
+		{"12345", 1, 0, 6, false},
+		{"123456", 2, 6, 7, false},
+		{"123456", 3, 13, 12, true},
+		{"ok", 4, 25, 3, false},
+	})
+}
+
+func TestLineReaderAfterOversizedLine(t *testing.T) {
+	// line longer than read buffer is read in several chunks
+	long := strings.Repeat("x", lineReaderBufferSize*2+100)
+	input := "before\n" + long + "\r\nafter\r\n" + long
+	got := readTestLines(t, input, 10)
+	size := int64(len(long))
+	compareTestLines(t, got, []testLine{
+		{"before", 1, 0, 7, false},
+		{"xxxxxxxxxx", 2, 7, size + 2, true},
+		{"after", 3, 7 + size + 2, 7, false},
+		{"xxxxxxxxxx", 4, 7 + size + 2 + 7, size, true},
+	})
+
+	got = readTestLines(t, input, 0)
+	if len(got) != 4 || got[1].data != long || got[1].truncated || got[2].data != "after" {
+		t.Errorf("unlimited lines are not read whole")
+	}
+}
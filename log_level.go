@@ -36,17 +36,17 @@ func parseLogLevel(level string) logLevel {
 	}
 }
 
-func levelToColor(level logLevel) string {
+func logLevelName(level logLevel) string {
 	switch level {
-	case logLevelTrace, logLevelDebug:
-		return "\u001b[32m" // green
+	case logLevelTrace:
+		return "trace"
+	case logLevelDebug:
+		return "debug"
 	case logLevelInfo:
-		return "" // no color
+		return "info"
 	case logLevelWarn:
-		return "\u001b[33m" // yellow
-	case logLevelError:
-		return "\u001b[31m" // red
+		return "warn"
 	default:
-		return "\u001b[36m" // cyan
+		return "error"
 	}
 }
@@ -1,15 +1,13 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
-	"encoding/xml"
 	"flag"
 	"fmt"
 	"os"
+	"runtime"
 	"runtime/trace"
-	"sort"
 	"strings"
 )
 
@@ -30,20 +28,133 @@ var wellKnownFields = map[string]int{
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "view" {
+		runView(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "fixture" {
+		runFixture(os.Args[2:])
+		return
+	}
+
 	filename := flag.String("filename", "", "filename to write decoded log")
 	infoFilename := flag.String("info", "", "filename to write decoded info and higher log")
 	errorFilename := flag.String("error", "", "filename to write decoded error log")
 	fixtureFile := flag.String("fixture", "", "filename to write request->response fixture")
+	fixtureNormalize := flag.Bool("fixturenormalize", false, "normalize fixture: sequential placeholders of uuids, no Date and Content-Length headers, credential headers from -redact redacted, sorted keys")
+	harFile := flag.String("har", "", "filename to write http request->response pairs as HAR 1.2 with timings from record timestamps")
+	reproFile := flag.String("repro", "", "filename to write captured http requests as curl script or .http file, credential headers from -redact are replaced by placeholders")
+	reproFormatName := flag.String("reproformat", "", "repro format: curl or http, default is selected by -repro file extension")
+	reproRequest := flag.String("reprorequest", "", "request_id to export by -repro and -ps1, default all requests")
+	ps1Dir := flag.String("ps1", "", "directory to write decoded powershell scripts of winrm commands as <request_id>.ps1")
 	original := flag.String("original", "", "filename to write original log")
+	htmlFile := flag.String("html", "", "filename to write self-contained html report")
+	jsonlFile := flag.String("jsonl", "", "filename to write normalized records as json lines, with -prefix any value writes prefix_log_records.jsonl")
+	sqliteFile := flag.String("sqlite", "", "sqlite database to insert records, http request/response pairs and winrm commands, requires sqlite3 shell")
+	redactFields := flag.String("redact", "authorization,password,token,cookie,set-cookie", "list of fields redacted in json lines output, -repro placeholders and -fixturenormalize headers, case-insensitive")
 	prefix := flag.String("prefix", "", "filename prefix for all logs")
 	skipFields := flag.String("skip", "", "list of fields to skip from dump")
+	bodyFields := flag.String("bodyfields", "body_string", "list of body fields to decode by content type")
 	skipEmpty := flag.Bool("skipempty", false, "skip fields with empty values")
 	writerNameField := flag.String("writername", "", "use field value as writer name")
+	writerPath := flag.String("writerpath", "", "template of writer file paths with {prefix}, {value} and {kind} placeholders, e.g. {prefix}/{value}/{kind}.log, kinds: decoded, info, error, original, index, html, records")
+	maxOpenWriters := flag.Int("maxopenwriters", 64, "max writers with open files, least recently used writers are closed and reopened on demand, 0 - unlimited")
+	maxWriters := flag.Int("maxwriters", 1000, "max distinct writer names, records of other names are written by default writer, 0 - unlimited")
+	color := flag.String("color", "auto", "color stdout: auto (terminal without NO_COLOR, or FORCE_COLOR set), always or never")
+	theme := flag.String("theme", "", "comma separated level.<level>=style, key=style and field.<name>=style on top of default level colors, none clears defaults, e.g. key=cyan,field.error=bold+red")
+	syntax := flag.Bool("syntax", true, "color field names and values by type and highlight json and xml values on colored stdout")
 	hideDebug := flag.Bool("hidedebug", false, "hide debug output from stdout")
+	compact := flag.String("compact", "", "write one line per record to stdout with template like \"{time} {level:5} {caller} {msg} {*}\" or default, {*} is remaining fields as key=value, lines are truncated to terminal width")
+	recordTemplate := flag.String("template", "", "text/template of records or @filename, {{define \"<msg>\"}} templates are used for records with this msg, helpers: level, color, xml, json, indent, truncate, since")
+	httpExchange := flag.Bool("httpexchange", false, "write http request and response pair by request_id after response record: status, latency, headers and decoded bodies, requests without response are reported at exit")
+	rotateSize := flag.String("rotatesize", "", "rotate decoded, info, error, original and json lines files larger than size, e.g. 100M")
+	rotateInterval := flag.Duration("rotateinterval", 0, "rotate files every interval aligned to wall clock, e.g. 1h")
+	rotateSuffix := flag.String("rotatesuffix", "number", "suffix of rotated files: number (.1 is newest) or time (.20060102-150405)")
+	rotateCompress := flag.Bool("rotatecompress", false, "gzip rotated files")
+	rotateKeep := flag.Int("rotatekeep", 0, "number of rotated files to keep, 0 - keep all")
 	bufferSize := flag.Int("buffersize", 65536, "output writer buffer size, 0 - not buffered")
 	traceFile := flag.String("trace", "", "output trace")
+	maxLine := flag.Int("maxline", 32*1048576, "max line length, longer lines are truncated and marked with offset")
+	recordHeader := flag.Bool("recordheader", false, "write `#line @ 0xoffset` header before each record in decoded files")
+	originalIndex := flag.Bool("originalindex", false, "write index of line numbers, input offsets and original log offsets to original log filename + .idx")
+	workers := flag.Int("workers", runtime.NumCPU(), "number of parallel decode workers, 1 - decode in main goroutine")
+	commandKey := flag.String("commandkey", "url", "powershell fixture command key: url, hash, firstline or regex:<expr> with named groups, falls back to hash")
+	xmlView := flag.String("xmlview", "pretty", "xml body view: pretty, compact (path = value lines) or both")
+	xmlDepth := flag.Int("xmldepth", 0, "xml body view depth limit, 0 - unlimited")
+	var xmlExtractFlags stringListFlag
+	flag.Var(&xmlExtractFlags, "xml-extract", "name=path to extract value from xml body as field, e.g. action=//Header/Action, can be repeated")
+	var highlightFlags stringListFlag
+	flag.Var(&highlightFlags, "highlight", "regular expression to highlight on colored stdout, e.g. a request id, can be repeated")
 	flag.Parse()
 
+	mode, err := parseColorMode(*color)
+	if err != nil {
+		fmt.Printf("Invalid color %s %s:", *color, err)
+		os.Exit(1)
+	}
+	colorOptions.enabled = detectColors(mode)
+	themeSpec := *theme
+	if *syntax {
+		themeSpec = withSyntaxTheme(themeSpec)
+	}
+	colorOptions.theme, err = parseTheme(themeSpec)
+	if err != nil {
+		fmt.Printf("Invalid theme %s %s:", *theme, err)
+		os.Exit(1)
+	}
+	colorOptions.highlights, err = compileHighlights(highlightFlags)
+	if err != nil {
+		fmt.Printf("Invalid highlight %s:", err)
+		os.Exit(1)
+	}
+
+	if err := setCommandKeyMode(*commandKey); err != nil {
+		fmt.Printf("Invalid commandkey %s %s:", *commandKey, err)
+		os.Exit(1)
+	}
+	xmlMode, err := parseXMLViewMode(*xmlView)
+	if err != nil {
+		fmt.Printf("Invalid xmlview %s %s:", *xmlView, err)
+		os.Exit(1)
+	}
+	xmlRenderOptions.mode = xmlMode
+	xmlRenderOptions.maxDepth = *xmlDepth
+
+	maxSize, err := parseByteSize(*rotateSize)
+	if err != nil {
+		fmt.Printf("Invalid rotatesize %s %s:", *rotateSize, err)
+		os.Exit(1)
+	}
+	suffix, err := parseRotateSuffix(*rotateSuffix)
+	if err != nil {
+		fmt.Printf("Invalid rotatesuffix %s %s:", *rotateSuffix, err)
+		os.Exit(1)
+	}
+	if *writerPath != "" {
+		if err := checkWriterPath(*writerPath); err != nil {
+			fmt.Printf("Invalid writerpath %s %s:", *writerPath, err)
+			os.Exit(1)
+		}
+	}
+
+	logRotation = rotateOptions{
+		maxSize:  maxSize,
+		interval: *rotateInterval,
+		suffix:   suffix,
+		compress: *rotateCompress,
+		keep:     *rotateKeep,
+	}
+
+	var xmlExtracts []*xmlExtract
+	for _, s := range xmlExtractFlags {
+		e, err := parseXMLExtract(s)
+		if err != nil {
+			fmt.Printf("Invalid xml-extract %s %s:", s, err)
+			os.Exit(1)
+		}
+		xmlExtracts = append(xmlExtracts, e)
+	}
+
 	if *traceFile != "" {
 		f, err := os.Create(*traceFile)
 		if err != nil {
@@ -63,153 +174,221 @@ func main() {
 		defer trace.Stop()
 	}
 
-	fixture := newFixture()
-	writers := make(map[string]*logWriter)
-	defer func() {
-		for _, w := range writers {
-			w.Close()
+	skipFieldsMap := make(map[string]struct{})
+	if *skipFields != "" {
+		for _, key := range strings.Split(*skipFields, ",") {
+			skipFieldsMap[key] = struct{}{}
 		}
-	}()
+	}
 
-	openWriter := func(writer *logWriter, additionalPrefix string) {
+	// sqlite3 shell is checked before output files are created
+	var sqlite *sqliteSink
+	if *sqliteFile != "" {
+		sqlite, err = newSQLiteSink(*sqliteFile, skipFieldsMap)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Open sqlite error %s: %s\n", *sqliteFile, err)
+			os.Exit(1)
+		}
+	}
+
+	fixture := newFixture()
+
+	pathsFor := func(value string) writerPaths {
+		if *writerPath != "" {
+			if value == "" {
+				value = defaultWriterName
+			}
+			return templateWriterPaths(*writerPath, *prefix, value, *originalIndex, *htmlFile != "", *jsonlFile != "")
+		}
+		additionalPrefix := ""
+		if value != "" {
+			additionalPrefix = "_" + value
+		}
+		withPrefix := func(filename string) string {
+			if filename == "" {
+				return ""
+			}
+			return additionalPrefix + filename
+		}
+		var paths writerPaths
 		if *prefix != "" {
-			err := writer.OpenWithPrefix(*prefix + additionalPrefix)
-			if err != nil {
-				fmt.Printf("OpenWithPrefix error %s %s:", *prefix, err)
-				os.Exit(1)
+			paths = prefixWriterPaths(*prefix + additionalPrefix)
+			if *originalIndex {
+				paths.index = paths.original + ".idx"
+			}
+			if *jsonlFile != "" {
+				paths.jsonl = fmt.Sprintf("%s_log_records.jsonl", *prefix+additionalPrefix)
 			}
 		} else {
-			err := writer.OpenAll(additionalPrefix+*filename, additionalPrefix+*infoFilename, additionalPrefix+*errorFilename, additionalPrefix+*original)
-			if err != nil {
-				fmt.Printf("OpenAll error %s %s:", *filename, err)
-				os.Exit(1)
+			paths = writerPaths{
+				decoded:  withPrefix(*filename),
+				info:     withPrefix(*infoFilename),
+				errors:   withPrefix(*errorFilename),
+				original: withPrefix(*original),
+				jsonl:    withPrefix(*jsonlFile),
+			}
+			if *originalIndex && *original != "" {
+				paths.index = withPrefix(*original + ".idx")
 			}
 		}
+		paths.html = withPrefix(*htmlFile)
+		return paths
 	}
 
-	createWriter := func(name string) *logWriter {
-		writer, ok := writers[name]
-		if ok {
-			return writer
-		}
-		writer = newWriter(*hideDebug)
+	openWriter := func(value string) *logWriter {
+		writer := newWriter(*hideDebug)
 		writer.bufferSize = *bufferSize
-		if name != "" {
-			openWriter(writer, "_"+name)
-		} else {
-			openWriter(writer, "")
+		err := writer.Open(pathsFor(value))
+		if err != nil {
+			fmt.Printf("Open writer error %s %s:", value, err)
+			os.Exit(1)
 		}
-
-		writers[name] = writer
 		return writer
 	}
-	defaulWriter := newWriter(*hideDebug)
-	defaulWriter.bufferSize = *bufferSize
-	defer defaulWriter.Close()
-	openWriter(defaulWriter, "")
+	defaulWriter := openWriter("")
+	writers := newWriterSet(defaulWriter, openWriter, *maxOpenWriters, *maxWriters)
+	defer writers.Close()
 
-	skipFieldsMap := make(map[string]struct{})
-	if *skipFields != "" {
-		for _, key := range strings.Split(*skipFields, ",") {
-			skipFieldsMap[key] = struct{}{}
+	bodyFieldsMap := make(map[string]struct{})
+	if *bodyFields != "" {
+		for _, key := range strings.Split(*bodyFields, ",") {
+			bodyFieldsMap[key] = struct{}{}
 		}
 	}
 
-	scanner := bufio.NewScanner(os.Stdin)
-	buffer := make([]byte, 0, 262144)
-	scanner.Buffer(buffer, 32*1048576)
+	decoder := &recordDecoder{
+		formatter:       newRecordFormatter(*hideDebug),
+		skipFields:      skipFieldsMap,
+		skipEmpty:       *skipEmpty,
+		bodyFields:      bodyFieldsMap,
+		xmlExtracts:     xmlExtracts,
+		writerNameField: *writerNameField,
+	}
+	decoder.formatter.htmlReport = *htmlFile != ""
+	if *recordTemplate != "" {
+		decoder.templates, err = parseRecordTemplates(*recordTemplate, colorOptions.enabled)
+		if err != nil {
+			fmt.Printf("Invalid template %s %s:", *recordTemplate, err)
+			os.Exit(1)
+		}
+	}
+	if *compact != "" {
+		decoder.formatter.compact, err = parseCompactTemplate(*compact)
+		if err != nil {
+			fmt.Printf("Invalid compact template %s %s:", *compact, err)
+			os.Exit(1)
+		}
+		decoder.formatter.compactWidth = watchTerminalWidth(int(os.Stdout.Fd()))
+	}
+	if *jsonlFile != "" {
+		decoder.jsonl = &jsonlOptions{redactFields: parseRedactFields(*redactFields)}
+	}
+
+	var exchanges *httpExchanges
+	if *httpExchange {
+		exchanges = newHTTPExchanges(decoder.formatter)
+		exchanges.jsonl = decoder.jsonl != nil
+	}
+
 	prevUnmarshalError := false
-	for scanner.Scan() {
+	err = runPipeline(os.Stdin, *maxLine, *workers, decoder.decode, func(rec *decodedRecord) {
+		if sqlite != nil {
+			sqlite.WriteRecord(rec)
+		}
 		writer := defaulWriter
-		fixture.processLine(scanner.Bytes())
-
-		var logLevel logLevel
-		linedata, err := unmarshal(scanner.Bytes())
-		if err != nil {
-			writer.WriteOriginal(scanner.Bytes())
-			text := strings.Trim(scanner.Text(), "\r\n")
+		if rec.truncated {
+			writer.WriteOriginal(rec.number, rec.offset, rec.line)
+			// text of undecoded line is written to decoded sink only, so is its header
+			if *recordHeader {
+				writer.WriteRecordHeader(rec.number, rec.offset, logLevelDebug)
+			}
+			writer.WriteTextAndError("Line too long", truncatedPreview(rec.line), rec.err)
+			prevUnmarshalError = true
+			return
+		}
+		if rec.err != nil {
+			writer.WriteOriginal(rec.number, rec.offset, rec.line)
+			if *recordHeader && !prevUnmarshalError {
+				writer.WriteRecordHeader(rec.number, rec.offset, logLevelDebug)
+			}
+			text := strings.Trim(string(rec.line), "\r\n")
 			if prevUnmarshalError {
 				writer.WriteText(text)
 			} else {
-				writer.WriteTextAndError("Unmarshal", text, err)
+				writer.WriteTextAndError("Unmarshal", text, rec.err)
 			}
 			prevUnmarshalError = true
-		} else {
-			var level string
-			levelIface := linedata["level"]
-			if levelIface != nil {
-				level, _ = levelIface.(string)
-			}
-			logLevel = parseLogLevel(level)
-
-			if writerNameField != nil && *writerNameField != "" {
-				var writerNameFieldValue string
-				writerNameFieldValueIface := linedata[*writerNameField]
-				if writerNameFieldValueIface != nil {
-					writerNameFieldValue, _ = writerNameFieldValueIface.(string)
-				}
-				writer = createWriter(writerNameFieldValue)
-			}
-			writer.WriteOriginal(scanner.Bytes())
-
-			prevUnmarshalError = false
-			type kv struct {
-				k string
-				v interface{}
-			}
-			sorted := make([]kv, 0)
-			for k, v := range linedata {
-				if _, skip := skipFieldsMap[k]; skip {
-					continue
-				}
-				if *skipEmpty && isEmpty(v) {
-					continue
-				}
-				sorted = append(sorted, kv{k, v})
-				sort.Slice(sorted, func(i, j int) bool {
-					wellKnown1, ok1 := wellKnownFields[sorted[i].k]
-					wellKnown2, ok2 := wellKnownFields[sorted[j].k]
-					if ok1 && ok2 {
-						return wellKnown1 < wellKnown2
-					}
-					if ok1 && !ok2 {
-						return true
-					}
-					if !ok1 && ok2 {
-						return false
-					}
-					return strings.Compare(sorted[i].k, sorted[j].k) < 0
-				})
-			}
+			return
+		}
 
-			for _, v := range sorted {
-				if v.k == "body_string" {
-					showXMLBody(writer.WriteIface, v.k, v.v)
-				}
-				switch v.v.(type) {
-				case map[string]interface{}:
-					writer.WriteIface(logLevel, v.k, v.v)
-				case []interface{}:
-					writer.WriteIface(logLevel, v.k, v.v)
-				default:
-					writer.WriteValue(logLevel, v.k, v.v)
-				}
-			}
+		fixture.processRecord(rec.record)
+		if *writerNameField != "" {
+			writer = writers.Get(rec.writerName)
+		}
+		writer.WriteOriginal(rec.number, rec.offset, rec.line)
+		if *recordHeader {
+			writer.WriteRecordHeader(rec.number, rec.offset, rec.level)
 		}
-		if !prevUnmarshalError && writer != nil {
-			writer.WriteNewLine(logLevel)
+		prevUnmarshalError = false
+		writer.WriteRecord(rec.output)
+		if exchanges != nil {
+			exchanges.processRecord(rec, writer)
 		}
+	})
+	if err != nil {
+		defaulWriter.WriteTextAndError("scanner error", "", err)
 	}
-	if scanner.Err() != nil {
-		defaulWriter.WriteTextAndError("scanner error", "", scanner.Err())
+
+	if exchanges != nil {
+		exchanges.Close(defaulWriter)
 	}
 
 	if *fixtureFile != "" {
+		if *fixtureNormalize {
+			fixture.normalizer = newFixtureNormalizer(parseRedactFields(*redactFields))
+		}
 		err := fixture.SaveToFile(*fixtureFile)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "SaveToFile error %s\n", err)
 		}
 	}
+
+	if *reproFile != "" {
+		format, err := parseReproFormat(*reproFormatName, *reproFile)
+		if err == nil {
+			err = fixture.SaveRepro(*reproFile, reproOptions{format: format, requestID: *reproRequest, redact: parseRedactFields(*redactFields)})
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "SaveRepro error %s\n", err)
+		}
+	}
+
+	if *ps1Dir != "" {
+		if err := fixture.SavePowerShell(*ps1Dir, *reproRequest); err != nil {
+			fmt.Fprintf(os.Stderr, "SavePowerShell error %s\n", err)
+		}
+	}
+
+	if *harFile != "" {
+		if err := fixture.SaveHAR(*harFile); err != nil {
+			fmt.Fprintf(os.Stderr, "SaveHAR error %s\n", err)
+		}
+	}
+
+	if sqlite != nil {
+		sqlite.WriteFixture(fixture)
+		if err := sqlite.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "sqlite error %s\n", err)
+		}
+	}
+}
+
+// truncatedPreview returns beginning of truncated line for decoded output
+func truncatedPreview(line []byte) string {
+	if len(line) > maxTruncatedPreview {
+		return string(line[:maxTruncatedPreview]) + "..."
+	}
+	return string(line)
 }
 
 func isEmpty(v interface{}) bool {
@@ -232,26 +411,6 @@ func isEmpty(v interface{}) bool {
 	}
 }
 
-func showXMLBody(show showFn, name string, value interface{}) {
-	str, ok := value.(string)
-	if !ok {
-		fmt.Fprintf(os.Stderr, "invalid body string xml")
-		return
-	}
-	n, err := decodeXML(str)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "invalid body string xml: %s", err)
-		return
-	}
-	show(logLevelInfo, name+"_xml_json", n)
-	data, err := xml.MarshalIndent(n, "", "  ")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "xml.MarshalIndent error: %s", err)
-		return
-	}
-	fmt.Printf("%s_xml: %s\n", name, string(data))
-}
-
 func unmarshal(data []byte) (map[string]interface{}, error) {
 	d := json.NewDecoder(bytes.NewReader(data))
 	d.UseNumber()
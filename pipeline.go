@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// pipelineBatchSize is a number of lines decoded by worker at once
+const pipelineBatchSize = 256
+
+// maxTruncatedPreview limits text of truncated line shown in decoded output
+const maxTruncatedPreview = 1024
+
+// decodedRecord is a decoded and formatted log line
+type decodedRecord struct {
+	line       []byte
+	number     int64
+	offset     int64
+	size       int64
+	truncated  bool
+	record     *parsedRecord
+	err        error
+	level      logLevel
+	writerName string
+	output     *formattedRecord
+}
+
+// recordDecoder decodes and formats log lines, safe for concurrent use
+type recordDecoder struct {
+	formatter       *recordFormatter
+	skipFields      map[string]struct{}
+	skipEmpty       bool
+	bodyFields      map[string]struct{}
+	xmlExtracts     []*xmlExtract
+	writerNameField string
+	jsonl           *jsonlOptions
+	templates       *recordTemplates
+}
+
+func (d *recordDecoder) decode(in *inputLine) *decodedRecord {
+	rec := &decodedRecord{
+		line:      in.data,
+		number:    in.number,
+		offset:    in.offset,
+		size:      in.size,
+		truncated: in.truncated,
+	}
+	if in.truncated {
+		rec.err = errors.Errorf("line of %d bytes at offset %d truncated to %d bytes", in.size, in.offset, len(in.data))
+		return rec
+	}
+	record, err := parseRecord(in.data)
+	if err != nil {
+		rec.err = err
+		return rec
+	}
+	rec.record = record
+	rec.level = parseLogLevel(record.GetString("level"))
+	extractXMLFields(record, d.bodyFields, d.xmlExtracts)
+
+	if d.writerNameField != "" {
+		rec.writerName = record.GetString(d.writerNameField)
+	}
+
+	sorted := make([]recordField, 0, len(record.fields))
+	for _, f := range record.fields {
+		if _, skip := d.skipFields[f.key]; skip {
+			continue
+		}
+		if d.skipEmpty && isEmpty(f.value) {
+			continue
+		}
+		sorted = append(sorted, f)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		wellKnown1, ok1 := wellKnownFields[sorted[i].key]
+		wellKnown2, ok2 := wellKnownFields[sorted[j].key]
+		if ok1 && ok2 {
+			return wellKnown1 < wellKnown2
+		}
+		if ok1 && !ok2 {
+			return true
+		}
+		if !ok1 && ok2 {
+			return false
+		}
+		return strings.Compare(sorted[i].key, sorted[j].key) < 0
+	})
+
+	out := d.formatter.newRecord()
+	if out.htmlReport {
+		out.level = rec.level
+		out.requestID = scalarField(record, "request_id")
+		out.title = fmt.Sprintf("#%d %s", in.number, recordSummary(record))
+	}
+	if d.templates == nil || !d.templates.write(out, rec.level, record) {
+		for _, f := range sorted {
+			if _, ok := d.bodyFields[f.key]; ok {
+				showBody(out.WriteValue, out.WriteIface, rec.level, f.key, f.value, record.Get("headers"))
+			}
+			switch f.value.(type) {
+			case map[string]interface{}:
+				out.WriteIface(rec.level, f.key, f.value)
+			case []interface{}:
+				out.WriteIface(rec.level, f.key, f.value)
+			default:
+				out.WriteValue(rec.level, f.key, f.value)
+			}
+		}
+		out.WriteNewLine(rec.level)
+	}
+	if out.compact != nil {
+		out.writeCompact(rec.level, sorted)
+	}
+	if d.jsonl != nil {
+		d.writeJSONL(&out.jsonl, rec)
+	}
+	rec.output = out
+	return rec
+}
+
+// runPipeline reads lines, decodes them with workers and calls write in input order.
+// Returns read error if reading stopped before end of input
+func runPipeline(r io.Reader, maxLine, workers int, decode func(in *inputLine) *decodedRecord, write func(rec *decodedRecord)) error {
+	lines := newLineReader(r, maxLine)
+
+	if workers <= 1 {
+		for lines.Next() {
+			write(decode(lines.Line()))
+		}
+		return lines.Err()
+	}
+
+	type batch struct {
+		lines  []inputLine
+		result chan []*decodedRecord
+	}
+	jobs := make(chan *batch, workers)
+	ordered := make(chan *batch, workers*2)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for b := range jobs {
+				records := make([]*decodedRecord, len(b.lines))
+				for i := range b.lines {
+					records[i] = decode(&b.lines[i])
+				}
+				b.result <- records
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		defer close(ordered)
+		batchLines := make([]inputLine, 0, pipelineBatchSize)
+		send := func() {
+			b := &batch{lines: batchLines, result: make(chan []*decodedRecord, 1)}
+			ordered <- b
+			jobs <- b
+			batchLines = make([]inputLine, 0, pipelineBatchSize)
+		}
+		for lines.Next() {
+			line := *lines.Line()
+			line.data = append([]byte(nil), line.data...)
+			batchLines = append(batchLines, line)
+			if len(batchLines) == pipelineBatchSize {
+				send()
+			}
+		}
+		if len(batchLines) > 0 {
+			send()
+		}
+	}()
+
+	for b := range ordered {
+		for _, rec := range <-b.result {
+			write(rec)
+		}
+	}
+	return lines.Err()
+}
+
+// scalarField formats scalar field value, empty for missing, null and nested values
+func scalarField(record *parsedRecord, key string) string {
+	value := record.Get(key)
+	if value == nil {
+		return ""
+	}
+	s, _ := formatScalar(value)
+	return s
+}
+
+// recordSummary returns one line `time level msg [request_id]` description of record
+func recordSummary(record *parsedRecord) string {
+	var parts []string
+	for _, key := range []string{"time", "level", "msg"} {
+		if s := scalarField(record, key); s != "" {
+			parts = append(parts, s)
+		}
+	}
+	if requestID := scalarField(record, "request_id"); requestID != "" {
+		parts = append(parts, "["+requestID+"]")
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// syntheticLog generates log of json records with xml and json bodies,
+// undecodable lines and lines longer than maxLine of testDecoder
+func syntheticLog(lines int) []byte {
+	var b bytes.Buffer
+	levels := []string{"debug", "info", "warn", "error"}
+	for i := 0; i < lines; i++ {
+		switch {
+		case i%997 == 500:
+			fmt.Fprintf(&b, "{\"level\":\"info\",\"msg\":\"long\",\"data\":\"%s\"}\n", strings.Repeat("x", 5000))
+		case i%101 == 50:
+			fmt.Fprintf(&b, "panic: line %d is not json\r\n", i)
+		case i%7 == 0:
+			fmt.Fprintf(&b, `{"time":"2021-06-01T10:00:%02d.%03dZ","level":"debug","msg":"http_request","request_id":"r%d","method":"POST","url":"http://host:5985/wsman","headers":{"Content-Type":["application/soap+xml"]},"body_string":"<s:Envelope xmlns:s=\"http://www.w3.org/2003/05/soap-envelope\"><s:Body><rsp:Receive xmlns:rsp=\"http://schemas.microsoft.com/wbem/wsman/1/windows/shell\"><rsp:DesiredStream CommandId=\"%d\">stdout stderr</rsp:DesiredStream></rsp:Receive></s:Body></s:Envelope>"}`+"\n", i%60, i%1000, i, i)
+		case i%5 == 0:
+			fmt.Fprintf(&b, `{"time":"2021-06-01T10:00:%02dZ","level":"%s","msg":"http_response","request_id":"r%d","status_code":200,"headers":{"Content-Type":["application/json"]},"body_string":"{\"id\":%d,\"items\":[1,2,3],\"name\":\"n\\u00e9\"}"}`+"\n", i%60, levels[i%4], i, i)
+		default:
+			fmt.Fprintf(&b, `{"time":"2021-06-01T10:00:%02dZ","level":"%s","msg":"step %d","caller":"main.go:%d","n":%d.5,"ok":%t,"tags":["a","b"]}`+"\n", i%60, levels[i%4], i, i%300, i, i%2 == 0)
+		}
+	}
+	return b.Bytes()
+}
+
+const testMaxLine = 4096
+
+func testDecoder() *recordDecoder {
+	return &recordDecoder{
+		formatter:  newRecordFormatter(false),
+		skipFields: map[string]struct{}{},
+		bodyFields: map[string]struct{}{"body_string": {}},
+		jsonl:      &jsonlOptions{redactFields: parseRedactFields("authorization")},
+	}
+}
+
+// decodeLog runs pipeline and returns everything written in order of write calls
+func decodeLog(t testing.TB, input []byte, workers int) []byte {
+	var out bytes.Buffer
+	var prev int64
+	err := runPipeline(bytes.NewReader(input), testMaxLine, workers, testDecoder().decode, func(rec *decodedRecord) {
+		if rec.number != prev+1 {
+			t.Fatalf("workers %d: record #%d written after #%d", workers, rec.number, prev)
+		}
+		prev = rec.number
+		fmt.Fprintf(&out, "#%d @ 0x%x %d %t %v\n", rec.number, rec.offset, rec.size, rec.truncated, rec.err)
+		if rec.output != nil {
+			out.Write(rec.output.stdout.Bytes())
+			out.Write(rec.output.decoded.Bytes())
+			out.Write(rec.output.info.Bytes())
+			out.Write(rec.output.errors.Bytes())
+			out.Write(rec.output.jsonl.Bytes())
+		}
+	})
+	if err != nil {
+		t.Fatalf("workers %d: runPipeline error %s", workers, err)
+	}
+	return out.Bytes()
+}
+
+func TestPipelineWorkersOutputIdentical(t *testing.T) {
+	input := syntheticLog(5000)
+	single := decodeLog(t, input, 1)
+	if !bytes.Contains(single, []byte(" true line of ")) {
+		t.Fatal("synthetic log has no truncated lines")
+	}
+	for _, workers := range []int{2, 8} {
+		parallel := decodeLog(t, input, workers)
+		if !bytes.Equal(single, parallel) {
+			t.Errorf("-workers %d output differs from -workers 1: %d and %d bytes", workers, len(parallel), len(single))
+		}
+	}
+}
+
+func TestPipelineLastLineWithoutNewline(t *testing.T) {
+	for _, workers := range []int{1, 8} {
+		out := decodeLog(t, []byte("{\"msg\":\"a\"}\r\n{\"msg\":\"b\"}"), workers)
+		if !bytes.Contains(out, []byte("#2 @ 0xd 11 false")) {
+			t.Errorf("workers %d: last line is not decoded:\n%s", workers, out)
+		}
+	}
+}
+
+// benchLogEnv is a path of real log for pipeline benchmarks, e.g. multi-GB production log
+const benchLogEnv = "LOG_DECODER_BENCH_LOG"
+
+// benchmarkPipeline decodes log from benchLogEnv file or about 70MB of synthetic log,
+// output is checked for order and discarded
+func benchmarkPipeline(b *testing.B, workers int) {
+	var input []byte
+	filename := os.Getenv(benchLogEnv)
+	if filename == "" {
+		input = syntheticLog(300000)
+		b.SetBytes(int64(len(input)))
+	} else {
+		fi, err := os.Stat(filename)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.SetBytes(fi.Size())
+	}
+	decoder := testDecoder()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var r io.Reader = bytes.NewReader(input)
+		if filename != "" {
+			f, err := os.Open(filename)
+			if err != nil {
+				b.Fatal(err)
+			}
+			r = f
+		}
+		var prev int64
+		err := runPipeline(r, testMaxLine, workers, decoder.decode, func(rec *decodedRecord) {
+			if rec.number != prev+1 {
+				b.Fatalf("record #%d written after #%d", rec.number, prev)
+			}
+			prev = rec.number
+		})
+		if f, ok := r.(*os.File); ok {
+			f.Close()
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPipelineWorkers1(b *testing.B) {
+	benchmarkPipeline(b, 1)
+}
+
+func BenchmarkPipelineWorkersNumCPU(b *testing.B) {
+	benchmarkPipeline(b, runtime.NumCPU())
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// commandKeyFn builds fixture command key from decoded powershell script,
+// returns false if script has no key, then hash of script is used
+type commandKeyFn func(script string) (string, bool)
+
+// maxFirstLineKeyLength limits length of first-line command keys
+const maxFirstLineKeyLength = 120
+
+var urlRegexp = regexp.MustCompile(".*\\s-Uri\\s*\\\"(.*?)\\\"")
+
+var whitespaceRegexp = regexp.MustCompile(`\s+`)
+
+// powerShellCommandKey is used by parseRequest, set by -commandkey flag
+var powerShellCommandKey commandKeyFn = urlCommandKey
+
+// setCommandKeyMode selects command key: url, hash, firstline or regex:<expr>
+func setCommandKeyMode(mode string) error {
+	switch {
+	case mode == "" || mode == "url":
+		powerShellCommandKey = urlCommandKey
+	case mode == "hash":
+		powerShellCommandKey = hashCommandKey
+	case mode == "firstline":
+		powerShellCommandKey = firstLineCommandKey
+	case strings.HasPrefix(mode, "regex:"):
+		re, err := regexp.Compile(mode[len("regex:"):])
+		if err != nil {
+			return errors.Wrap(err, "regexp.Compile failed")
+		}
+		powerShellCommandKey = regexCommandKey(re)
+	default:
+		return errors.Errorf("Unknown command key mode %s", mode)
+	}
+	return nil
+}
+
+// urlCommandKey returns -Uri "..." argument from script
+func urlCommandKey(script string) (string, bool) {
+	ss := urlRegexp.FindStringSubmatch(script)
+	if len(ss) < 2 {
+		return "", false
+	}
+	return ss[1], true
+}
+
+// hashCommandKey returns hash of script with collapsed whitespace
+func hashCommandKey(script string) (string, bool) {
+	normalized := whitespaceRegexp.ReplaceAllString(strings.TrimSpace(script), " ")
+	sum := sha256.Sum256([]byte(normalized))
+	return "sha256:" + hex.EncodeToString(sum[:8]), true
+}
+
+// firstLineCommandKey returns first non-empty line of script
+func firstLineCommandKey(script string) (string, bool) {
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if len(line) > maxFirstLineKeyLength {
+			line = line[:maxFirstLineKeyLength] + "..."
+		}
+		return line, true
+	}
+	return "", false
+}
+
+// regexCommandKey joins named groups of first match, or returns whole match
+func regexCommandKey(re *regexp.Regexp) commandKeyFn {
+	return func(script string) (string, bool) {
+		ss := re.FindStringSubmatch(script)
+		if ss == nil {
+			return "", false
+		}
+		var parts []string
+		for i, name := range re.SubexpNames() {
+			if name != "" && ss[i] != "" {
+				parts = append(parts, ss[i])
+			}
+		}
+		if len(parts) == 0 {
+			return ss[0], true
+		}
+		return strings.Join(parts, " "), true
+	}
+}
+
+// isPowerShellCommand checks if command line starts powershell.exe or pwsh
+func isPowerShellCommand(cmd string) bool {
+	lower := strings.ToLower(strings.TrimLeft(cmd, "\" "))
+	return strings.HasPrefix(lower, "powershell") || strings.HasPrefix(lower, "pwsh")
+}
+
+// commandLineToken is a command line argument with its end offset
+type commandLineToken struct {
+	text string
+	end  int
+}
+
+// splitCommandLine splits command line by whitespace, keeping quoted strings together
+func splitCommandLine(cmd string) []commandLineToken {
+	var result []commandLineToken
+	var b strings.Builder
+	inToken := false
+	quoted := false
+	for i, c := range cmd {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			inToken = true
+		case (c == ' ' || c == '\t') && !quoted:
+			if inToken {
+				result = append(result, commandLineToken{b.String(), i})
+				b.Reset()
+				inToken = false
+			}
+		default:
+			b.WriteRune(c)
+			inToken = true
+		}
+	}
+	if inToken {
+		result = append(result, commandLineToken{b.String(), len(cmd)})
+	}
+	return result
+}
+
+// matchPowerShellParam checks abbreviated parameter name like -enc for -EncodedCommand
+func matchPowerShellParam(arg, name string, aliases ...string) bool {
+	if len(arg) < 2 || (arg[0] != '-' && arg[0] != '/') {
+		return false
+	}
+	arg = strings.ToLower(arg[1:])
+	for _, alias := range aliases {
+		if arg == alias {
+			return true
+		}
+	}
+	return len(arg) >= 3 && strings.HasPrefix(name, arg)
+}
+
+// decodePowerShell extracts script from -EncodedCommand or -Command argument
+func decodePowerShell(cmd string) (string, error) {
+	tokens := splitCommandLine(cmd)
+	for i, t := range tokens {
+		if matchPowerShellParam(t.text, "encodedcommand", "e", "ec") {
+			if i+1 >= len(tokens) {
+				return "", errors.Errorf("Not found encoded command argument")
+			}
+			return decodeEncodedCommand(tokens[i+1].text)
+		}
+		if matchPowerShellParam(t.text, "command", "c") {
+			script := strings.TrimSpace(cmd[t.end:])
+			if len(script) >= 2 && strings.HasPrefix(script, "\"") && strings.HasSuffix(script, "\"") {
+				script = script[1 : len(script)-1]
+			}
+			return script, nil
+		}
+	}
+	return "", errors.Errorf("Not found powershell command argument")
+}
+
+// decodeEncodedCommand decodes base64 UTF-16LE script
+func decodeEncodedCommand(encoded string) (string, error) {
+	unicodeScript, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", errors.Wrap(err, "DecodeString failed")
+	}
+
+	decoder := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder()
+	b, err := decoder.Bytes(unicodeScript)
+	if err != nil {
+		return "", errors.Wrap(err, "decoder.Bytes failed")
+	}
+	return string(b), nil
+}
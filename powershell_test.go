@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/base64"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"golang.org/x/text/encoding/unicode"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want []commandLineToken
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"powershell", []commandLineToken{{"powershell", 10}}},
+		{"a  b\tc", []commandLineToken{{"a", 1}, {"b", 4}, {"c", 6}}},
+		{` "C:\Program Files\pwsh.exe" -c x`, []commandLineToken{{`C:\Program Files\pwsh.exe`, 28}, {"-c", 31}, {"x", 33}}},
+		{`-Command "Get-Item 'a b'" next`, []commandLineToken{{"-Command", 8}, {"Get-Item 'a b'", 25}, {"next", 30}}},
+		{`a"b c"d e`, []commandLineToken{{"ab cd", 7}, {"e", 9}}},
+		{`""`, []commandLineToken{{"", 2}}},
+		{`"unterminated arg`, []commandLineToken{{"unterminated arg", 17}}},
+		{"ключ значение", []commandLineToken{{"ключ", 8}, {"значение", 25}}},
+	}
+	for _, tt := range tests {
+		if got := splitCommandLine(tt.cmd); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCommandLine(%q) = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func encodePowerShell(t *testing.T, script string) string {
+	b, err := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder().Bytes([]byte(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func TestDecodePowerShell(t *testing.T) {
+	script := "$r = Invoke-RestMethod -Uri \"http://localhost/api\"\nWrite-Output $r"
+	encoded := encodePowerShell(t, script)
+	tests := []struct {
+		cmd     string
+		want    string
+		wantErr bool
+	}{
+		{"powershell.exe -NoProfile -EncodedCommand " + encoded, script, false},
+		{"powershell -enc " + encoded, script, false},
+		{"pwsh -e " + encoded + " -NoLogo", script, false},
+		{"powershell /ec " + encoded, script, false},
+		{`powershell -Command "Get-Date; Get-Item 'a b'"`, "Get-Date; Get-Item 'a b'", false},
+		{"powershell -c Get-Date -Format o", "Get-Date -Format o", false},
+		{"powershell -NoProfile", "", true},
+		{"powershell -EncodedCommand", "", true},
+		{"powershell -EncodedCommand not-base64!", "", true},
+	}
+	for _, tt := range tests {
+		got, err := decodePowerShell(tt.cmd)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("decodePowerShell(%q) = %q, %v, want %q, error %t", tt.cmd, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMatchPowerShellParam(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want bool
+	}{
+		{"-EncodedCommand", true},
+		{"-encodedcommand", true},
+		{"-enc", true},
+		{"/enc", true},
+		{"-e", true},
+		{"-ec", true},
+		{"-en", false},
+		{"-Execution", false},
+		{"enc", false},
+		{"-", false},
+	}
+	for _, tt := range tests {
+		if got := matchPowerShellParam(tt.arg, "encodedcommand", "e", "ec"); got != tt.want {
+			t.Errorf("matchPowerShellParam(%q) = %t", tt.arg, got)
+		}
+	}
+}
+
+func TestIsPowerShellCommand(t *testing.T) {
+	for cmd, want := range map[string]bool{
+		"powershell.exe -c x":       true,
+		`"PowerShell" -c x`:         true,
+		"pwsh -c x":                 true,
+		"cmd.exe /c powershell":     false,
+		"  powershell -NoProfile x": true,
+	} {
+		if got := isPowerShellCommand(cmd); got != want {
+			t.Errorf("isPowerShellCommand(%q) = %t", cmd, got)
+		}
+	}
+}
+
+func TestCommandKeys(t *testing.T) {
+	withURL := "$r = Invoke-RestMethod -Method Get -Uri \"http://localhost:8080/api/v1/status\"\n$r | ConvertTo-Json"
+	withoutURL := "\n\n  Get-Service   winrm  \nGet-Date"
+
+	if key, ok := urlCommandKey(withURL); !ok || key != "http://localhost:8080/api/v1/status" {
+		t.Errorf("urlCommandKey = %q, %t", key, ok)
+	}
+	if key, ok := urlCommandKey(withoutURL); ok || key != "" {
+		t.Errorf("urlCommandKey without url = %q, %t", key, ok)
+	}
+
+	hash, ok := hashCommandKey(withoutURL)
+	if !ok || len(hash) != len("sha256:")+16 {
+		t.Errorf("hashCommandKey = %q, %t", hash, ok)
+	}
+	if same, _ := hashCommandKey("Get-Service winrm\n\tGet-Date "); same != hash {
+		t.Errorf("hash depends on whitespace: %q and %q", same, hash)
+	}
+
+	if key, ok := firstLineCommandKey(withoutURL); !ok || key != "Get-Service   winrm" {
+		t.Errorf("firstLineCommandKey = %q, %t", key, ok)
+	}
+	if _, ok := firstLineCommandKey(" \n\t\n"); ok {
+		t.Error("firstLineCommandKey of empty script")
+	}
+
+	named := regexCommandKey(regexp.MustCompile(`-Method (?P<method>\w+) -Uri "(?P<url>[^"]+)"`))
+	if key, ok := named(withURL); !ok || key != "Get http://localhost:8080/api/v1/status" {
+		t.Errorf("named regex key = %q, %t", key, ok)
+	}
+	whole := regexCommandKey(regexp.MustCompile(`Get-\w+`))
+	if key, ok := whole(withoutURL); !ok || key != "Get-Service" {
+		t.Errorf("regex key = %q, %t", key, ok)
+	}
+	if _, ok := whole("Set-Item x"); ok {
+		t.Error("regex key without match")
+	}
+}
+
+func TestSetCommandKeyMode(t *testing.T) {
+	defer func() { powerShellCommandKey = urlCommandKey }()
+	for _, mode := range []string{"", "url", "hash", "firstline", "regex:-Uri (\\S+)"} {
+		if err := setCommandKeyMode(mode); err != nil {
+			t.Errorf("%q: %s", mode, err)
+		}
+	}
+	for _, mode := range []string{"md5", "regex:("} {
+		if err := setCommandKeyMode(mode); err == nil {
+			t.Errorf("%q: error expected", mode)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// recordFormatter contains output settings shared by all writers
+type recordFormatter struct {
+	needColors bool
+	theme      *colorTheme
+	highlights []*regexp.Regexp
+	warnColor  string
+	resetColor string
+	hideDebug  bool
+	htmlReport bool
+
+	// compact replaces multi-line stdout records by single line, truncated to compactWidth
+	compact      *compactTemplate
+	compactWidth *terminalWidth
+}
+
+// formattedRecord contains record output for stdout and each decoded sink,
+// so records can be formatted concurrently and written in order
+type formattedRecord struct {
+	*recordFormatter
+	stdout  bytes.Buffer
+	decoded bytes.Buffer
+	info    bytes.Buffer
+	errors  bytes.Buffer
+	jsonl   bytes.Buffer
+
+	// html report card, rendered only when htmlReport is set
+	level     logLevel
+	requestID string
+	title     string
+	html      strings.Builder
+}
+
+func newRecordFormatter(hideDebug bool) *recordFormatter {
+	needColors := colorOptions.enabled
+	theme := colorOptions.theme
+	warnColor := ""
+	resetColor := ""
+	if needColors {
+		warnColor = theme.level(logLevelWarn)
+		resetColor = resetStyle
+	}
+	return &recordFormatter{
+		needColors: needColors,
+		theme:      theme,
+		highlights: colorOptions.highlights,
+		warnColor:  warnColor,
+		resetColor: resetColor,
+		hideDebug:  hideDebug,
+	}
+}
+
+func (f *recordFormatter) newRecord() *formattedRecord {
+	return &formattedRecord{recordFormatter: f}
+}
+
+func (r *formattedRecord) WriteIface(level logLevel, name string, value interface{}) {
+	b, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Marshal error %s\n", err)
+		return
+	}
+	r.writeField(level, name, string(b), valueJSON)
+	if r.htmlReport {
+		writeHTMLField(&r.html, name, value)
+	}
+}
+
+func (r *formattedRecord) WriteValue(level logLevel, name string, value interface{}) {
+	s, ok := formatScalar(value)
+	if !ok {
+		s = fmt.Sprintf("%+v", value)
+	}
+	// s = strings.TrimSpace(s)
+	// s = strings.Replace(s, "\n\n", "\\n\n", -1)
+	// s = strings.Replace(s, "\r\n\r\n", "\\r\\n\n", -1)
+	if strings.Contains(s, "\n") {
+		s = strings.Replace(s, "\n", "\n\t\t", -1)
+		s = fmt.Sprintf("| \n\t\t%s", s)
+	}
+	r.writeField(level, name, s, valueKindOf(value))
+	if r.htmlReport {
+		writeHTMLField(&r.html, name, value)
+	}
+}
+
+// writeField writes field to stdout and decoded sinks, kind selects syntax coloring of stdout value
+func (r *formattedRecord) writeField(level logLevel, name, s string, kind valueKind) {
+	if r.compact == nil && (!r.hideDebug || level.IsInfoOrHigher()) {
+		color := ""
+		if r.needColors {
+			color = r.theme.level(level)
+		}
+		r.stdout.WriteString(color)
+		if r.needColors && (!r.theme.plain() || len(r.highlights) > 0) {
+			r.writeStyledField(color, name, s, kind)
+		} else {
+			writeFieldLine(&r.stdout, name, s, r.resetColor)
+		}
+	}
+	writeFieldLine(&r.decoded, name, s, "")
+	if level.IsInfoOrHigher() {
+		writeFieldLine(&r.info, name, s, "")
+	}
+	if level.IsErrorOrWarn() {
+		writeFieldLine(&r.errors, name, s, "")
+	}
+}
+
+// writeFieldLine writes "name: value" line with optional color reset suffix
+func writeFieldLine(b *bytes.Buffer, name, s, suffix string) {
+	b.WriteString(name)
+	b.WriteString(": ")
+	b.WriteString(s)
+	b.WriteString(suffix)
+	b.WriteByte('\n')
+}
+
+// writeStyledField writes "name: value" line to stdout with theme styles of field name and value,
+// syntax styles of value kind and -highlight matches, restoring line color after each styled part
+func (r *formattedRecord) writeStyledField(lineColor, name, s string, kind valueKind) {
+	b := &r.stdout
+	if r.theme.key != "" {
+		b.WriteString(r.theme.key)
+		b.WriteString(name)
+		b.WriteString(resetStyle)
+		b.WriteString(lineColor)
+	} else {
+		b.WriteString(name)
+	}
+	b.WriteString(": ")
+	var spans []styledSpan
+	if style := r.theme.fields[name]; style != "" {
+		spans = []styledSpan{{0, len(s), style}}
+	} else {
+		spans = r.theme.valueSpans(s, kind)
+	}
+	writeSpans(b, s, spans, highlightSpans(s, r.highlights), r.theme.highlightStyle(), lineColor)
+	b.WriteString(resetStyle)
+	b.WriteByte('\n')
+}
+
+// writeBlock writes multi-line text of record, colored text to stdout and plain text to decoded sinks.
+// Compact mode keeps blocks out of stdout, the record is written there by writeCompact
+func (r *formattedRecord) writeBlock(level logLevel, colored, plain string) {
+	if !strings.HasSuffix(plain, "\n") {
+		colored += "\n"
+		plain += "\n"
+	}
+	if r.compact == nil && (!r.hideDebug || level.IsInfoOrHigher()) {
+		r.stdout.WriteString(colored)
+	}
+	r.decoded.WriteString(plain)
+	if level.IsInfoOrHigher() {
+		r.info.WriteString(plain)
+	}
+	if level.IsErrorOrWarn() {
+		r.errors.WriteString(plain)
+	}
+}
+
+func (r *formattedRecord) WriteNewLine(level logLevel) {
+	if r.compact == nil && (!r.hideDebug || level.IsInfoOrHigher()) {
+		r.stdout.WriteString("\n")
+	}
+	r.decoded.WriteString("\n\n")
+	if level.IsInfoOrHigher() {
+		r.info.WriteString("\n\n")
+	}
+	if level.IsErrorOrWarn() {
+		r.errors.WriteString("\n\n")
+	}
+}
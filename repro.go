@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type reproFormat int
+
+const (
+	reproCurl reproFormat = iota
+	reproHTTPFile
+)
+
+// reproOptions configures export of captured requests as curl script or .http file
+type reproOptions struct {
+	format    reproFormat
+	requestID string
+	// redact are lower case names of headers replaced by placeholders
+	redact map[string]struct{}
+}
+
+// parseReproFormat parses -reproformat, empty format is selected by file extension
+func parseReproFormat(format, filename string) (reproFormat, error) {
+	switch format {
+	case "":
+		if strings.EqualFold(filepath.Ext(filename), ".http") {
+			return reproHTTPFile, nil
+		}
+		return reproCurl, nil
+	case "curl":
+		return reproCurl, nil
+	case "http":
+		return reproHTTPFile, nil
+	default:
+		return reproCurl, errors.Errorf("Unknown repro format %s", format)
+	}
+}
+
+// selectPairs returns pairs of request id, all pairs for empty id
+func (f *fixture) selectPairs(requestID string) []*requestResponse {
+	if requestID == "" {
+		return f.data
+	}
+	var pairs []*requestResponse
+	for _, pair := range f.data {
+		if pair.RequestID == requestID {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
+
+// SaveRepro writes captured requests as runnable curl commands or .http file,
+// credentials are replaced by placeholders
+func (f *fixture) SaveRepro(filename string, opts reproOptions) error {
+	pairs := f.selectPairs(opts.requestID)
+	if len(pairs) == 0 && opts.requestID != "" {
+		return errors.Errorf("request %s not found", opts.requestID)
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrapf(err, "Create %s failed", filename)
+	}
+	wr := bufio.NewWriter(file)
+	placeholders := reproPlaceholders(pairs, opts.redact)
+	if opts.format == reproHTTPFile {
+		writeHTTPFile(wr, pairs, placeholders, opts.redact)
+	} else {
+		writeCurlScript(wr, pairs, placeholders, opts.redact)
+	}
+	if err := mergeErrors(wr.Flush(), file.Close()); err != nil {
+		return errors.Wrapf(err, "write %s failed", filename)
+	}
+	if opts.format == reproCurl {
+		return os.Chmod(filename, 0770)
+	}
+	return nil
+}
+
+// reproPlaceholders returns sorted placeholder names of redacted headers and url passwords
+func reproPlaceholders(pairs []*requestResponse, redact map[string]struct{}) []string {
+	names := make(map[string]struct{})
+	for _, pair := range pairs {
+		for name := range pair.Request.Headers {
+			if _, ok := redact[strings.ToLower(name)]; ok {
+				names[placeholderName(name)] = struct{}{}
+			}
+		}
+		if u, err := url.Parse(pair.Url); err == nil && u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				names["URL_PASSWORD"] = struct{}{}
+			}
+		}
+	}
+	list := make([]string, 0, len(names))
+	for name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// placeholderName converts header name to variable name, e.g. Set-Cookie to SET_COOKIE
+func placeholderName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// reproURL replaces url password by placeholder
+func reproURL(rawURL, placeholder string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	if _, ok := u.User.Password(); !ok {
+		return rawURL
+	}
+	user := u.User.Username()
+	u.User = nil
+	s := u.String()
+	i := strings.Index(s, "://")
+	if i < 0 {
+		return rawURL
+	}
+	return s[:i+3] + url.PathEscape(user) + ":" + placeholder + "@" + s[i+3:]
+}
+
+// reproSummary is a comment line describing pair
+func reproSummary(pair *requestResponse) string {
+	s := fmt.Sprintf("request %s %s %s", pair.RequestID, pair.Method, reproURL(pair.Url, "xxxxx"))
+	if pair.Status != "" || pair.StatusCode != 0 {
+		s += fmt.Sprintf(", response %d %s", pair.StatusCode, strings.TrimSpace(strings.TrimPrefix(pair.Status, fmt.Sprint(pair.StatusCode))))
+	}
+	if pair.SOAPRequest != nil && pair.SOAPRequest.Action != "" {
+		s += ", " + pair.SOAPRequest.Action
+	}
+	return s
+}
+
+func sortedHeaderNames(headers http.Header) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeCurlScript(wr *bufio.Writer, pairs []*requestResponse, placeholders []string, redact map[string]struct{}) {
+	wr.WriteString("#!/bin/sh\n# http requests decoded from log\n")
+	if len(placeholders) > 0 {
+		wr.WriteString("# set credentials before running:\n")
+		for _, name := range placeholders {
+			fmt.Fprintf(wr, "#   export %s=...\n", name)
+		}
+	}
+	wr.WriteString("set -e\n")
+	for _, pair := range pairs {
+		fmt.Fprintf(wr, "\n# %s\n", reproSummary(pair))
+		method := pair.Method
+		if method == "" {
+			method = "GET"
+		}
+		// placeholder is inserted after escaping, so only it is expanded by shell
+		u := strings.Replace(shellEscapeDouble(reproURL(pair.Url, "\x00")), "\x00", "${URL_PASSWORD:?}", 1)
+		fmt.Fprintf(wr, "curl -sS -X %s \"%s\"", shellQuote(method), u)
+		for _, name := range sortedHeaderNames(pair.Request.Headers) {
+			_, secret := redact[strings.ToLower(name)]
+			for _, value := range pair.Request.Headers[name] {
+				if secret {
+					fmt.Fprintf(wr, " \\\n  -H \"%s: ${%s:?}\"", shellEscapeDouble(name), placeholderName(name))
+				} else {
+					fmt.Fprintf(wr, " \\\n  -H %s", shellQuote(name+": "+value))
+				}
+			}
+		}
+		if pair.requestBody != "" {
+			fmt.Fprintf(wr, " \\\n  --data-binary %s", shellQuote(pair.requestBody))
+		}
+		wr.WriteString("\n")
+	}
+}
+
+func writeHTTPFile(wr *bufio.Writer, pairs []*requestResponse, placeholders []string, redact map[string]struct{}) {
+	wr.WriteString("# http requests decoded from log\n")
+	if len(placeholders) > 0 {
+		wr.WriteString("# set credentials before sending\n")
+		for _, name := range placeholders {
+			fmt.Fprintf(wr, "@%s = \n", name)
+		}
+	}
+	for _, pair := range pairs {
+		fmt.Fprintf(wr, "\n### %s\n", reproSummary(pair))
+		method := pair.Method
+		if method == "" {
+			method = "GET"
+		}
+		fmt.Fprintf(wr, "%s %s\n", method, reproURL(pair.Url, "{{URL_PASSWORD}}"))
+		for _, name := range sortedHeaderNames(pair.Request.Headers) {
+			_, secret := redact[strings.ToLower(name)]
+			for _, value := range pair.Request.Headers[name] {
+				if secret {
+					value = "{{" + placeholderName(name) + "}}"
+				}
+				fmt.Fprintf(wr, "%s: %s\n", name, value)
+			}
+		}
+		if pair.requestBody != "" {
+			wr.WriteString("\n")
+			wr.WriteString(strings.TrimRight(pair.requestBody, "\r\n"))
+			wr.WriteString("\n")
+		}
+	}
+}
+
+// shellQuote quotes string for sh in single quotes
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// shellEscapeDouble escapes string for sh double quotes
+func shellEscapeDouble(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\', '`', '$':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// SavePowerShell writes decoded powershell scripts of winrm command requests to dir as <request_id>.ps1
+func (f *fixture) SavePowerShell(dir, requestID string) error {
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return errors.Wrapf(err, "MkdirAll %s failed", dir)
+	}
+	used := make(map[string]int)
+	for _, pair := range f.selectPairs(requestID) {
+		r := pair.SOAPRequest
+		if r == nil || r.Command == "" {
+			continue
+		}
+		script := r.Script
+		if script == "" {
+			var err error
+			if script, err = decodePowerShell(r.Command); err != nil || script == "" {
+				continue
+			}
+		}
+		name := sanitizeWriterName(pair.RequestID)
+		if name == "" {
+			name = "request"
+		}
+		used[name]++
+		if n := used[name]; n > 1 {
+			name = fmt.Sprintf("%s_%d", name, n)
+		}
+		filename := filepath.Join(dir, name+".ps1")
+		content := fmt.Sprintf("# %s\n%s\n", reproSummary(pair), strings.TrimRight(script, "\r\n"))
+		if err := ioutil.WriteFile(filename, []byte(content), 0660); err != nil {
+			return errors.Wrapf(err, "WriteFile %s failed", filename)
+		}
+	}
+	return nil
+}
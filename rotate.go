@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type rotateSuffix int
+
+const (
+	rotateSuffixNumber rotateSuffix = iota
+	rotateSuffixTime
+)
+
+// rotateTimeFormat is a suffix of rotated file in timestamp mode
+const rotateTimeFormat = "20060102-150405"
+
+// rotateOptions configures rotation of decoded, info, error, original and json lines files
+type rotateOptions struct {
+	maxSize  int64
+	interval time.Duration
+	suffix   rotateSuffix
+	compress bool
+	keep     int
+}
+
+// logRotation is set by -rotate* flags, zero value disables rotation
+var logRotation rotateOptions
+
+func (o *rotateOptions) enabled() bool {
+	return o.maxSize > 0 || o.interval > 0
+}
+
+func parseRotateSuffix(suffix string) (rotateSuffix, error) {
+	switch suffix {
+	case "", "number":
+		return rotateSuffixNumber, nil
+	case "time":
+		return rotateSuffixTime, nil
+	default:
+		return rotateSuffixNumber, errors.Errorf("Unknown rotate suffix %s", suffix)
+	}
+}
+
+// parseByteSize parses size with optional K, M or G suffix
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	s = strings.TrimSuffix(s, "B")
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.Errorf("invalid size %s", s)
+	}
+	return n * multiplier, nil
+}
+
+// rotatingFile is a buffered log file rotated by size or time interval.
+// Files are rotated only at line start, so records are not split between files
+type rotatingFile struct {
+	filename    string
+	opts        rotateOptions
+	bufferSize  int
+	file        *os.File
+	wr          *bufio.Writer
+	size        int64
+	period      time.Time
+	atLineStart bool
+	// onRotate is called after rotation, e.g. to rotate index together with original log
+	onRotate func() error
+}
+
+func openRotatingFile(filename string, bufferSize int, opts rotateOptions) (*rotatingFile, error) {
+	r := &rotatingFile{
+		filename:   filename,
+		opts:       opts,
+		bufferSize: bufferSize,
+	}
+	if err := r.open(filename); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens filename for append and makes it current file only if it is opened
+func (r *rotatingFile) open(filename string) error {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0660)
+	if err != nil {
+		return errors.Wrapf(err, "OpenFile %s failed", filename)
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.Wrapf(err, "Stat %s failed", filename)
+	}
+	r.file = file
+	r.size = fi.Size()
+	r.atLineStart = true
+	r.period = r.periodOf(time.Now())
+	if r.size > 0 {
+		// continue period of existing file, so it is rotated after restart if period is over
+		r.period = r.periodOf(fi.ModTime())
+	}
+	bufferSize := r.bufferSize
+	if bufferSize <= 0 {
+		bufferSize = 4096
+	}
+	r.wr = bufio.NewWriterSize(file, bufferSize)
+	return nil
+}
+
+// periodOf returns start of rotation interval containing t, intervals are aligned to wall clock
+func (r *rotatingFile) periodOf(t time.Time) time.Time {
+	if r.opts.interval <= 0 {
+		return time.Time{}
+	}
+	return t.Truncate(r.opts.interval)
+}
+
+func (r *rotatingFile) needRotate(n int64) bool {
+	if r.size == 0 || !r.atLineStart {
+		return false
+	}
+	if r.opts.maxSize > 0 && r.size+n > r.opts.maxSize {
+		return true
+	}
+	return r.opts.interval > 0 && !r.periodOf(time.Now()).Equal(r.period)
+}
+
+// RotateBefore rotates file if write of n bytes would exceed limits
+func (r *rotatingFile) RotateBefore(n int64) {
+	if r.needRotate(n) {
+		if err := r.Rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Rotate %s error %s\n", r.filename, err)
+		}
+	}
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.RotateBefore(int64(len(p)))
+	if r.file == nil {
+		// previous rotation failed to open any file
+		if err := r.open(r.filename); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.wr.Write(p)
+	r.size += int64(n)
+	if n > 0 {
+		r.atLineStart = p[n-1] == '\n'
+	}
+	if err == nil && r.bufferSize <= 0 {
+		err = r.wr.Flush()
+	}
+	return n, err
+}
+
+func (r *rotatingFile) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err1 := r.wr.Flush()
+	err2 := r.file.Close()
+	r.file = nil
+	return mergeErrors(err1, err2)
+}
+
+// Rotate renames current file, opens new file, compresses rotated one and removes files above
+// retention count. If rename or open fails, writing continues to the current file
+func (r *rotatingFile) Rotate() error {
+	if r.file == nil {
+		return r.open(r.filename)
+	}
+	if err := r.wr.Flush(); err != nil {
+		return errors.Wrap(err, "Flush before rotate failed")
+	}
+	// file is closed before rename, open files can not be renamed on windows
+	if err := r.Close(); err != nil {
+		return mergeErrors(err, r.open(r.filename))
+	}
+	var rotated string
+	var err error
+	if r.opts.suffix == rotateSuffixTime {
+		rotated, err = r.rotateTime()
+	} else {
+		rotated, err = r.rotateNumber()
+	}
+	if err != nil && rotated == "" {
+		return mergeErrors(err, r.open(r.filename))
+	}
+	if openErr := r.open(r.filename); openErr != nil {
+		return mergeErrors(err, openErr, r.open(rotated))
+	}
+	if err == nil && r.opts.compress {
+		err = compressFile(rotated)
+	}
+	if err != nil {
+		return err
+	}
+	if r.onRotate != nil {
+		return r.onRotate()
+	}
+	return nil
+}
+
+// rotatedExt returns extension of rotated files
+func (r *rotatingFile) rotatedExt() string {
+	if r.opts.compress {
+		return ".gz"
+	}
+	return ""
+}
+
+// rotateNumber shifts filename.N to filename.N+1 and renames file to filename.1
+func (r *rotatingFile) rotateNumber() (string, error) {
+	ext := r.rotatedExt()
+	numbered := func(i int) string {
+		return fmt.Sprintf("%s.%d", r.filename, i)
+	}
+	last := 0
+	for {
+		if _, err := os.Stat(numbered(last+1) + ext); err != nil {
+			break
+		}
+		last++
+	}
+	for i := last; i >= 1; i-- {
+		if r.opts.keep > 0 && i >= r.opts.keep {
+			if err := os.Remove(numbered(i) + ext); err != nil {
+				return "", errors.Wrap(err, "remove rotated file")
+			}
+			continue
+		}
+		if err := os.Rename(numbered(i)+ext, numbered(i+1)+ext); err != nil {
+			return "", errors.Wrap(err, "rename rotated file")
+		}
+	}
+	if err := os.Rename(r.filename, numbered(1)); err != nil {
+		return "", errors.Wrap(err, "rename log file")
+	}
+	return numbered(1), nil
+}
+
+// rotateTime renames file to filename.timestamp and removes oldest files above retention count
+func (r *rotatingFile) rotateTime() (string, error) {
+	base := r.filename + "." + time.Now().Format(rotateTimeFormat)
+	rotated := base
+	for i := 1; ; i++ {
+		if _, err := os.Stat(rotated + r.rotatedExt()); err != nil {
+			break
+		}
+		rotated = fmt.Sprintf("%s-%d", base, i)
+	}
+	if err := os.Rename(r.filename, rotated); err != nil {
+		return "", errors.Wrap(err, "rename log file")
+	}
+	if r.opts.keep <= 0 {
+		return rotated, nil
+	}
+	matches, err := filepath.Glob(r.filename + ".[0-9]*")
+	if err != nil {
+		return rotated, errors.Wrap(err, "list rotated files")
+	}
+	var files []string
+	for _, m := range matches {
+		suffix := m[len(r.filename)+1:]
+		if len(suffix) < len(rotateTimeFormat) {
+			continue
+		}
+		if _, err := time.Parse(rotateTimeFormat, suffix[:len(rotateTimeFormat)]); err == nil {
+			files = append(files, m)
+		}
+	}
+	sort.Strings(files)
+	for len(files) > r.opts.keep {
+		if err := os.Remove(files[0]); err != nil {
+			return rotated, errors.Wrap(err, "remove rotated file")
+		}
+		files = files[1:]
+	}
+	return rotated, nil
+}
+
+// compressFile replaces file with its gzip compressed copy
+func compressFile(filename string) error {
+	src, err := os.Open(filename)
+	if err != nil {
+		return errors.Wrap(err, "open rotated file")
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(filename+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0660)
+	if err != nil {
+		return errors.Wrap(err, "create compressed file")
+	}
+	gz := gzip.NewWriter(dst)
+	_, errCopy := io.Copy(gz, src)
+	errGzip := gz.Close()
+	errClose := dst.Close()
+	if err := mergeErrors(errCopy, errGzip, errClose); err != nil {
+		os.Remove(filename + ".gz")
+		return errors.Wrap(err, "compress rotated file")
+	}
+	src.Close()
+	return os.Remove(filename)
+}
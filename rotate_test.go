@@ -0,0 +1,196 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"1024", 1024, false},
+		{"10K", 10 << 10, false},
+		{"10kb", 10 << 10, false},
+		{" 100M ", 100 << 20, false},
+		{"2G", 2 << 30, false},
+		{"1.5M", 0, true},
+		{"-1", 0, true},
+		{"M1", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestParseRotateSuffix(t *testing.T) {
+	for in, want := range map[string]rotateSuffix{"": rotateSuffixNumber, "number": rotateSuffixNumber, "time": rotateSuffixTime} {
+		if got, err := parseRotateSuffix(in); err != nil || got != want {
+			t.Errorf("parseRotateSuffix(%q) = %d, %v", in, got, err)
+		}
+	}
+	if _, err := parseRotateSuffix("date"); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func readTestFile(t *testing.T, filename string) string {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func writeTestLines(t *testing.T, r *rotatingFile, lines ...string) {
+	for _, line := range lines {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRotatingFileBySize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "decoded.log")
+
+	r, err := openRotatingFile(filename, 0, rotateOptions{maxSize: 10, keep: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// record is not split between files, even if it is written in parts
+	writeTestLines(t, r, "line1\n", "line2", "-end\n", "line3\n", "line4\n")
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, filename); got != "line4\n" {
+		t.Errorf("current file %q", got)
+	}
+	if got := readTestFile(t, filename+".1"); got != "line3\n" {
+		t.Errorf(".1 file %q", got)
+	}
+	if got := readTestFile(t, filename+".2"); got != "line2-end\n" {
+		t.Errorf(".2 file %q", got)
+	}
+	if _, err := os.Stat(filename + ".3"); !os.IsNotExist(err) {
+		t.Errorf("file above retention count is kept: %v", err)
+	}
+}
+
+func TestRotatingFileCompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "decoded.log")
+
+	r, err := openRotatingFile(filename, 4096, rotateOptions{maxSize: 8, compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestLines(t, r, "first\n", "second\n")
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filename + ".1"); !os.IsNotExist(err) {
+		t.Errorf("uncompressed rotated file is kept: %v", err)
+	}
+	f, err := os.Open(filename + ".1.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(gz)
+	if err != nil || string(data) != "first\n" {
+		t.Errorf("compressed file %q, %v", data, err)
+	}
+	if got := readTestFile(t, filename); got != "second\n" {
+		t.Errorf("current file %q", got)
+	}
+}
+
+func TestRotatingFileTimeSuffix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "decoded.log")
+
+	r, err := openRotatingFile(filename, 0, rotateOptions{maxSize: 3, suffix: rotateSuffixTime, keep: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestLines(t, r, "a\n", "b\n", "c\n", "d\n")
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	matches, err := filepath.Glob(filename + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(matches)
+	if len(matches) != 2 {
+		t.Fatalf("rotated files %v, 2 expected", matches)
+	}
+	for _, m := range matches {
+		suffix := strings.TrimPrefix(m, filename+".")
+		if _, err := time.Parse(rotateTimeFormat, suffix[:len(rotateTimeFormat)]); err != nil {
+			t.Errorf("rotated file %s has no time suffix", m)
+		}
+	}
+	if got := readTestFile(t, matches[0]) + readTestFile(t, matches[1]) + readTestFile(t, filename); got != "b\nc\nd\n" {
+		t.Errorf("content %q", got)
+	}
+}
+
+func TestRotatingFileKeepsWritingAfterFailedRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "decoded.log")
+
+	// non-empty directory in place of rotated file can not be removed, so rotation fails
+	if err := os.MkdirAll(filepath.Join(filename+".1", "busy"), 0770); err != nil {
+		t.Fatal(err)
+	}
+	r, err := openRotatingFile(filename, 4096, rotateOptions{maxSize: 100, keep: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestLines(t, r, "a\n", "b\n")
+	if err := r.Rotate(); err == nil {
+		t.Error("rotation error expected")
+	}
+	writeTestLines(t, r, "c\n")
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, filename); got != "a\nb\nc\n" {
+		t.Errorf("records are lost after failed rotation: %q", got)
+	}
+}
@@ -6,11 +6,8 @@ import (
 	"encoding/xml"
 	"fmt"
 	"os"
-	"regexp"
+	"strconv"
 	"strings"
-
-	"github.com/pkg/errors"
-	"golang.org/x/text/encoding/unicode"
 )
 
 type winrmRequest struct {
@@ -24,19 +21,24 @@ type winrmRequest struct {
 	SignalCode    string `xml:"Body>Signal>Code" json:"SignalCode,omitempty"`
 	PowerShell    bool   `json:"powershell"`
 	CommandKey    string `json:"CommandKey,omitempty"`
+	Script        string `json:"Script,omitempty"`
 }
 
 type winrmResponse struct {
-	Action            string           `xml:"Header>Action"`
-	ResourceURI       string           `xml:"Header>ResourceURI" json:"ResourceURI,omitempty"`
-	Selector          string           `xml:"Header>SelectorSet>Selector" json:"Selector,omitempty"`
-	Stream            []responseStream `xml:"Body>ReceiveResponse>Stream" json:"Stream,omitempty"`
-	ExitCode          string           `xml:"Body>ReceiveResponse>CommandState>ExitCode" json:"ExitCode,omitempty"`
-	SignalResponse    string           `xml:"Body>SignalResponse" json:"SignalResponse,omitempty"`
-	ShellID           string           `xml:"Body>Shell>ShellId" json:"ShellId,omitempty"`
-	CommandStdout     string           `json:"command_stdout,omitempty"`
-	CommandStdoutJSON interface{}      `json:"command_stdout_json,omitempty"`
-	CommandStderr     string           `json:"command_stderr,omitempty"`
+	Action              string           `xml:"Header>Action"`
+	ResourceURI         string           `xml:"Header>ResourceURI" json:"ResourceURI,omitempty"`
+	Selector            string           `xml:"Header>SelectorSet>Selector" json:"Selector,omitempty"`
+	Stream              []responseStream `xml:"Body>ReceiveResponse>Stream" json:"Stream,omitempty"`
+	ExitCode            string           `xml:"Body>ReceiveResponse>CommandState>ExitCode" json:"ExitCode,omitempty"`
+	SignalResponse      string           `xml:"Body>SignalResponse" json:"SignalResponse,omitempty"`
+	ShellID             string           `xml:"Body>Shell>ShellId" json:"ShellId,omitempty"`
+	CommandStdout       string           `json:"command_stdout,omitempty"`
+	CommandStdoutJSON   interface{}      `json:"command_stdout_json,omitempty"`
+	CommandStderr       string           `json:"command_stderr,omitempty"`
+	CommandStderrText   string           `json:"command_stderr_text,omitempty"`
+	CommandStderrCLIXML *clixmlStreams   `json:"command_stderr_clixml,omitempty"`
+	// CommandStdoutJSONError is set when stdout is not json
+	CommandStdoutJSONError error `json:"-"`
 }
 
 type responseStream struct {
@@ -46,96 +48,124 @@ type responseStream struct {
 	Value     string `xml:",chardata"`
 }
 
-const powerShellCommandPrefix = "-EncodedCommand "
-
-var urlRegexp = regexp.MustCompile(".*\\s-Uri\\s*\\\"(.*?)\\\"")
-
 func parseRequest(body string) (*winrmRequest, error) {
 	var r winrmRequest
 	err := xml.Unmarshal([]byte(body), &r)
 	if err != nil {
 		return nil, err
 	}
-	r.PowerShell = strings.HasPrefix(strings.ToLower(r.Command), "powershell")
+	r.PowerShell = isPowerShellCommand(r.Command)
 	if !r.PowerShell {
 		r.CommandKey = r.Command
 	} else {
-		key, err := decodePowerShell(r.Command)
+		script, err := decodePowerShell(r.Command)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "decodePowerShell error: %s\n", err)
-		} else {
-			r.CommandKey = key
+			return &r, nil
+		}
+		r.Script = script
+		// scripts without key of selected mode, e.g. without -Uri, are identified by hash
+		key, ok := powerShellCommandKey(script)
+		if !ok {
+			key, _ = hashCommandKey(script)
 		}
+		r.CommandKey = key
 	}
 	return &r, nil
 }
 
-func decodePowerShell(cmd string) (string, error) {
-	i := strings.Index(cmd, powerShellCommandPrefix)
-	if i < 0 {
-		return "", errors.Errorf("Not fount powershell command prefix")
-	}
-	unicodeScript, err := base64.StdEncoding.DecodeString(cmd[i+len(powerShellCommandPrefix):])
-	if err != nil {
-		return "", errors.Wrap(err, "DecodeString failed")
+func parseResponse(body string) (*winrmResponse, error) {
+	r, err := decodeResponse(body)
+	if r != nil && r.CommandStdoutJSONError != nil {
+		fmt.Fprintf(os.Stderr, "json.Unmarshal stdout failed: %s\n", r.CommandStdoutJSONError)
 	}
+	return r, err
+}
 
-	decoder := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder()
-	b, err := decoder.Bytes(unicodeScript)
+// decodeResponse parses winrm soap response, stdout json decoding error is kept in response
+func decodeResponse(body string) (*winrmResponse, error) {
+	var r winrmResponse
+	err := xml.Unmarshal([]byte(body), &r)
 	if err != nil {
-		return "", errors.Wrap(err, "decoder.Bytes failed")
+		return nil, err
 	}
-	script := string(b)
+	if len(r.Stream) > 0 {
+		stdout := decodeStream(r.Stream, "stdout")
+		var jsonData interface{}
+		r.CommandStdoutJSONError = json.Unmarshal([]byte(stdout), &jsonData)
+		if r.CommandStdoutJSONError == nil {
+			r.CommandStdoutJSON = jsonData
+		}
 
-	ss := urlRegexp.FindStringSubmatch(script)
-	// for i, s := range  {
-	// 	fmt.Fprintf(os.Stderr, "%d:%s\n", i, s)
-	// }
-	if len(ss) < 2 {
-		return "", errors.Errorf("Not found url in %s", script)
+		r.CommandStdout = stdout
+		r.CommandStderr, r.CommandStderrCLIXML = decodeStderr(r.Stream)
+		if r.CommandStderrCLIXML != nil {
+			r.CommandStderrText = r.CommandStderrCLIXML.Error
+		}
 	}
+	return &r, nil
+}
 
-	return ss[1], nil
+// decodeStream concatenates base64 encoded chunks of named stream
+func decodeStream(streams []responseStream, name string) string {
+	var b strings.Builder
+	for _, s := range streams {
+		if s.Name != name {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(s.Value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "DecodeString %s failed at %+v %s\n", name, s, err)
+		} else {
+			b.Write(value)
+		}
+	}
+	return b.String()
 }
 
-func parseResponse(body string) (*winrmResponse, error) {
-	var r winrmResponse
-	err := xml.Unmarshal([]byte(body), &r)
+// decodeStderr decodes stderr stream, CLIXML is also decoded into readable streams
+func decodeStderr(streams []responseStream) (string, *clixmlStreams) {
+	stderr := decodeStream(streams, "stderr")
+	if !isCLIXML(stderr) {
+		return stderr, nil
+	}
+	decoded, err := decodeCLIXML(stderr)
 	if err != nil {
-		return nil, err
+		fmt.Fprintf(os.Stderr, "decodeCLIXML stderr failed: %s\n", err)
+		return stderr, nil
 	}
-	if len(r.Stream) > 0 {
-		var stdout strings.Builder
-		var stderr strings.Builder
-		for _, s := range r.Stream {
-			if s.Name == "stdout" {
-				value, err := base64.StdEncoding.DecodeString(s.Value)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "DecodeString stdout failed at %+v %s\n", s, err)
-				} else {
-					stdout.Write(value)
-				}
-			}
-			if s.Name == "stderr" {
-				value, err := base64.StdEncoding.DecodeString(s.Value)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "DecodeString stderr failed at %+v %s\n", s, err)
-				} else {
-					stderr.Write(value)
-				}
+	return stderr, decoded
+}
 
+// nodeResponseStreams reads streams of winrm soap response node the way xml.Unmarshal fills winrmResponse
+func nodeResponseStreams(n *Node) ([]responseStream, error) {
+	var streams []responseStream
+	for _, s := range nodePath(n, "Body", "ReceiveResponse", "Stream") {
+		stream := responseStream{Value: s.CharData}
+		for _, attr := range s.Attributes {
+			switch attr.Name.Local {
+			case "Name":
+				stream.Name = attr.Value
+			case "CommandId":
+				stream.CommandId = attr.Value
+			case "End":
+				end, err := strconv.ParseBool(strings.TrimSpace(attr.Value))
+				if err != nil && attr.Value != "" {
+					return nil, err
+				}
+				stream.End = end
 			}
 		}
-		var jsonData interface{}
-		err := json.Unmarshal([]byte(stdout.String()), &jsonData)
-		if err == nil {
-			r.CommandStdoutJSON = jsonData
-		} else {
-			fmt.Fprintf(os.Stderr, "json.Unmarshal stdout failed: %s\n", err)
-		}
+		streams = append(streams, stream)
+	}
+	return streams, nil
+}
 
-		r.CommandStdout = stdout.String()
-		r.CommandStderr = stderr.String()
+// nodeRequestCommand returns command line of winrm soap request node
+func nodeRequestCommand(n *Node) string {
+	var command string
+	for _, c := range nodePath(n, "Body", "CommandLine", "Command") {
+		command = c.CharData
 	}
-	return &r, nil
+	return command
 }
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// sqliteSchema creates tables for records, http request/response pairs and winrm commands,
+// rows of all tables have run_id of decoder run, so database can collect several runs
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	started TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now'))
+);
+CREATE TABLE IF NOT EXISTS records (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	run_id INTEGER NOT NULL REFERENCES runs(id),
+	line INTEGER NOT NULL,
+	offset INTEGER NOT NULL,
+	writer TEXT,
+	time TEXT,
+	level TEXT NOT NULL,
+	msg TEXT,
+	caller TEXT,
+	request_id TEXT,
+	trace_id TEXT,
+	fields TEXT,
+	parse_error TEXT,
+	raw TEXT
+);
+CREATE INDEX IF NOT EXISTS records_request_id ON records(run_id, request_id);
+CREATE INDEX IF NOT EXISTS records_time ON records(run_id, time);
+CREATE TABLE IF NOT EXISTS http_pairs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	run_id INTEGER NOT NULL REFERENCES runs(id),
+	request_id TEXT NOT NULL,
+	url TEXT,
+	method TEXT,
+	status_code INTEGER,
+	status TEXT,
+	request_headers TEXT,
+	request_body TEXT,
+	response_headers TEXT,
+	response_body TEXT,
+	soap_request TEXT,
+	soap_response TEXT
+);
+CREATE INDEX IF NOT EXISTS http_pairs_request_id ON http_pairs(run_id, request_id);
+CREATE TABLE IF NOT EXISTS winrm_commands (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	run_id INTEGER NOT NULL REFERENCES runs(id),
+	request_id TEXT,
+	command TEXT,
+	script TEXT,
+	exit_code INTEGER,
+	stdout TEXT,
+	stdout_json TEXT,
+	stderr TEXT,
+	errors TEXT,
+	progress TEXT
+);
+CREATE INDEX IF NOT EXISTS winrm_commands_request_id ON winrm_commands(run_id, request_id);
+`
+
+// sqliteColumnFields are record fields stored in typed columns of records table
+var sqliteColumnFields = map[string]struct{}{
+	"time":       {},
+	"level":      {},
+	"msg":        {},
+	"caller":     {},
+	"request_id": {},
+	"trace_id":   {},
+}
+
+// sqliteSink inserts records into sqlite database with sqlite3 command line shell,
+// all statements of a run are executed in a single transaction
+type sqliteSink struct {
+	cmd        *exec.Cmd
+	stdin      io.WriteCloser
+	wr         *bufio.Writer
+	skipFields map[string]struct{}
+	stmt       bytes.Buffer
+	err        error
+}
+
+func newSQLiteSink(filename string, skipFields map[string]struct{}) (*sqliteSink, error) {
+	path, err := exec.LookPath("sqlite3")
+	if err != nil {
+		return nil, errors.New("sqlite3 command line shell is not found in PATH, it is required by -sqlite")
+	}
+	cmd := exec.Command(path, "-batch", "-bail", filename)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "StdinPipe failed")
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrapf(err, "start %s failed", path)
+	}
+	s := &sqliteSink{
+		cmd:        cmd,
+		stdin:      stdin,
+		wr:         bufio.NewWriterSize(stdin, 65536),
+		skipFields: skipFields,
+	}
+	s.exec(sqliteSchema)
+	// run id is kept in connection's temp table, so concurrent runs on the same database do not mix rows
+	s.exec("BEGIN;\nINSERT INTO runs DEFAULT VALUES;\nCREATE TEMP TABLE current_run AS SELECT last_insert_rowid() AS id;\n")
+	return s, s.err
+}
+
+func (s *sqliteSink) exec(sql string) {
+	if s.err != nil {
+		return
+	}
+	if _, err := s.wr.WriteString(sql); err != nil {
+		s.err = errors.Wrap(err, "write to sqlite3 failed")
+		fmt.Fprintf(os.Stderr, "sqlite error %s\n", s.err)
+	}
+}
+
+// insert writes INSERT statement, values must be already quoted
+func (s *sqliteSink) insert(table string, columns []string, values []string) {
+	s.stmt.Reset()
+	s.stmt.WriteString("INSERT INTO ")
+	s.stmt.WriteString(table)
+	s.stmt.WriteString(" (run_id, ")
+	s.stmt.WriteString(strings.Join(columns, ", "))
+	s.stmt.WriteString(") VALUES ((SELECT id FROM current_run), ")
+	s.stmt.WriteString(strings.Join(values, ", "))
+	s.stmt.WriteString(");\n")
+	s.exec(s.stmt.String())
+}
+
+// WriteRecord inserts decoded record or line which failed to decode
+func (s *sqliteSink) WriteRecord(rec *decodedRecord) {
+	columns := []string{"line", "offset", "writer", "level"}
+	values := []string{sqlInt(rec.number), sqlInt(rec.offset), sqlText(rec.writerName)}
+	if rec.record == nil {
+		values = append(values, sqlText(logLevelName(logLevelWarn)))
+		columns = append(columns, "parse_error", "raw")
+		values = append(values, sqlText(rec.err.Error()), sqlText(truncatedPreview(rec.line)))
+		s.insert("records", columns, values)
+		return
+	}
+	values = append(values, sqlText(logLevelName(rec.level)))
+	columns = append(columns, "time", "msg", "caller", "request_id", "trace_id", "fields")
+	ts, _ := normalizeTime(rec.record.Get("time")).(string)
+	values = append(values,
+		sqlText(ts),
+		sqlText(scalarField(rec.record, "msg")),
+		sqlText(scalarField(rec.record, "caller")),
+		sqlText(scalarField(rec.record, "request_id")),
+		sqlText(scalarField(rec.record, "trace_id")),
+	)
+	rest := make([]recordField, 0, len(rec.record.fields))
+	for _, f := range rec.record.fields {
+		if _, ok := sqliteColumnFields[f.key]; ok {
+			continue
+		}
+		if _, skip := s.skipFields[f.key]; skip {
+			continue
+		}
+		rest = append(rest, f)
+	}
+	var b bytes.Buffer
+	writeJSONObject(&b, rest)
+	values = append(values, sqlText(b.String()))
+	s.insert("records", columns, values)
+}
+
+// WriteFixture inserts http request/response pairs and winrm commands collected by fixture
+func (s *sqliteSink) WriteFixture(f *fixture) {
+	for _, pair := range f.data {
+		s.insert("http_pairs",
+			[]string{"request_id", "url", "method", "status_code", "status", "request_headers", "request_body", "response_headers", "response_body", "soap_request", "soap_response"},
+			[]string{
+				sqlText(pair.RequestID),
+				sqlText(pair.Url),
+				sqlText(pair.Method),
+				sqlInt(int64(pair.StatusCode)),
+				sqlText(pair.Status),
+				sqlJSON(pair.Request.Headers),
+				sqlText(pair.requestBody),
+				sqlJSON(pair.Response.Headers),
+				sqlText(pair.responseBody),
+				sqlJSON(pair.SOAPRequest),
+				sqlJSON(pair.SOAPResponse),
+			})
+	}
+	for _, cr := range f.commandResponses {
+		s.insert("winrm_commands",
+			[]string{"request_id", "command", "script", "exit_code", "stdout", "stdout_json", "stderr", "errors", "progress"},
+			[]string{
+				sqlText(cr.RequestID),
+				sqlText(cr.Command),
+				sqlText(cr.Script),
+				sqlInt(int64(cr.ExitCode)),
+				sqlText(cr.ResponseString),
+				sqlJSON(cr.Response),
+				sqlText(stderrText(cr)),
+				sqlJSON(cr.ResponseErrors),
+				sqlJSON(cr.ResponseProgress),
+			})
+	}
+}
+
+// Close commits transaction and waits for sqlite3 to finish
+func (s *sqliteSink) Close() error {
+	s.exec("COMMIT;\n")
+	errFlush := s.wr.Flush()
+	errClose := s.stdin.Close()
+	errWait := s.cmd.Wait()
+	if errWait != nil {
+		errWait = errors.Wrap(errWait, "sqlite3 failed")
+	}
+	return mergeErrors(s.err, errFlush, errClose, errWait)
+}
+
+// stderrText returns readable text of CLIXML stderr or raw stderr
+func stderrText(cr *commandResponse) string {
+	if cr.ResponseStderrText != "" {
+		return cr.ResponseStderrText
+	}
+	return cr.ResponseStderr
+}
+
+// sqlText quotes string literal, empty string is stored as NULL
+func sqlText(s string) string {
+	if s == "" {
+		return "NULL"
+	}
+	s = strings.Replace(s, "\x00", "", -1)
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func sqlInt(i int64) string {
+	return strconv.FormatInt(i, 10)
+}
+
+// sqlJSON quotes json encoded value, nil and empty values are stored as NULL
+func sqlJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sqlite json encode error %s\n", err)
+		return "NULL"
+	}
+	switch string(data) {
+	case "null", "[]", "{}":
+		return "NULL"
+	}
+	return sqlText(string(data))
+}
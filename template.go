@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// recordTemplateName is a name of main template, used for records without template named by msg
+const recordTemplateName = "record"
+
+// recordTemplates renders records with text/template. Template named by record msg,
+// e.g. {{define "http_request"}}, is used for records with this msg, main template for others.
+// Records without template are written field by field
+type recordTemplates struct {
+	plain   *template.Template
+	colored *template.Template
+	hasMain bool
+}
+
+// parseRecordTemplates parses -template value, @filename reads template from file.
+// Template with colors is parsed only for colored stdout
+func parseRecordTemplates(spec string, colors bool) (*recordTemplates, error) {
+	text := spec
+	if strings.HasPrefix(spec, "@") {
+		data, err := ioutil.ReadFile(spec[1:])
+		if err != nil {
+			return nil, errors.Wrap(err, "read template")
+		}
+		text = string(data)
+	}
+	parse := func(colors bool) (*template.Template, error) {
+		return template.New(recordTemplateName).Funcs(templateFuncs(colors)).Option("missingkey=zero").Parse(text)
+	}
+	t := &recordTemplates{}
+	var err error
+	if t.plain, err = parse(false); err != nil {
+		return nil, err
+	}
+	t.colored = t.plain
+	if colors {
+		if t.colored, err = parse(true); err != nil {
+			return nil, err
+		}
+	}
+	t.hasMain = t.plain.Tree != nil && strings.TrimSpace(t.plain.Tree.Root.String()) != ""
+	return t, nil
+}
+
+// lookup returns name of template for record msg, empty if record has no template
+func (t *recordTemplates) lookup(msg string) string {
+	if msg != "" && msg != recordTemplateName && t.plain.Lookup(msg) != nil {
+		return msg
+	}
+	if t.hasMain {
+		return recordTemplateName
+	}
+	return ""
+}
+
+// write renders record with template to stdout and decoded sinks, returns false if record has no template.
+// Template errors are written as template_error field and record is written field by field
+func (t *recordTemplates) write(out *formattedRecord, level logLevel, record *parsedRecord) bool {
+	name := t.lookup(scalarField(record, "msg"))
+	if name == "" {
+		return false
+	}
+	data := make(map[string]interface{}, len(record.fields))
+	for _, f := range record.fields {
+		data[f.key] = f.value
+	}
+	var plain, colored bytes.Buffer
+	err := t.plain.ExecuteTemplate(&plain, name, data)
+	if err == nil && out.needColors {
+		err = t.colored.ExecuteTemplate(&colored, name, data)
+	}
+	if err != nil {
+		out.WriteValue(logLevelWarn, "template_error", err.Error())
+		return false
+	}
+	if !out.needColors {
+		colored = plain
+	}
+	out.writeBlock(level, colored.String(), plain.String())
+	if out.htmlReport {
+		out.html.WriteString("<pre>")
+		out.html.WriteString(html.EscapeString(plain.String()))
+		out.html.WriteString("</pre>\n")
+	}
+	return true
+}
+
+// templateFuncs returns template helpers, color is applied only if colors is set
+func templateFuncs(colors bool) template.FuncMap {
+	return template.FuncMap{
+		// level returns canonical name of level field value
+		"level": func(v interface{}) string {
+			return logLevelName(parseLogLevel(templateText(v)))
+		},
+		// color styles text with theme level color (`color "error" .msg`) or style (`color "bold+red" .msg`)
+		"color": func(style string, v interface{}) (string, error) {
+			s := templateText(v)
+			if !colors {
+				return s, nil
+			}
+			code, ok := "", false
+			if level, isLevel := logLevelByName(style); isLevel {
+				code, ok = colorOptions.theme.level(level), true
+			}
+			if !ok {
+				var err error
+				if code, err = parseStyle(style); err != nil {
+					return "", err
+				}
+			}
+			if code == "" {
+				return s, nil
+			}
+			return code + s + resetStyle, nil
+		},
+		// xml pretty prints xml text with -xmlview settings, other text is returned as is
+		"xml": func(v interface{}) string {
+			s := templateText(v)
+			rendered, err := renderXML(s, xmlRenderOptions.mode, xmlRenderOptions.maxDepth)
+			if err != nil {
+				return s
+			}
+			return rendered
+		},
+		// json indents value or json text
+		"json": func(v interface{}) (string, error) {
+			if s, ok := v.(string); ok && sniffJSONBody(s) {
+				d := json.NewDecoder(strings.NewReader(s))
+				d.UseNumber()
+				if err := d.Decode(&v); err != nil {
+					return s, nil
+				}
+			}
+			data, err := json.MarshalIndent(v, "", "  ")
+			if err != nil {
+				return "", err
+			}
+			return string(data), nil
+		},
+		// indent prefixes each line by n spaces
+		"indent": func(n int, v interface{}) string {
+			pad := strings.Repeat(" ", n)
+			return pad + strings.Replace(templateText(v), "\n", "\n"+pad, -1)
+		},
+		// truncate cuts text to n runes, the last rune is replaced by ellipsis
+		"truncate": func(n int, v interface{}) string {
+			s := templateText(v)
+			if n <= 0 || utf8.RuneCountInString(s) <= n {
+				return s
+			}
+			runes := []rune(s)
+			return string(runes[:n-1]) + compactEllipsis
+		},
+		// since returns time elapsed since record time
+		"since": func(v interface{}) string {
+			t, ok := parseRecordTime(v)
+			if !ok {
+				return ""
+			}
+			return time.Since(t).Round(time.Millisecond).String()
+		},
+	}
+}
+
+// templateText formats template argument, missing field is empty text
+func templateText(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := formatScalar(v); ok {
+		return s
+	}
+	return fmt.Sprintf("%+v", v)
+}
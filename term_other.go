@@ -0,0 +1,31 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package main
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// isTerminal checks if file descriptor is a character device
+func isTerminal(fd int) bool {
+	fi, err := os.NewFile(uintptr(fd), "").Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
+
+func terminalSize(fd int) (int, int, error) {
+	return 0, 0, errors.New("terminal size is not supported on this platform")
+}
+
+func notifyResize(ch chan<- os.Signal) {
+}
+
+func enableVirtualTerminal(fd int) bool {
+	return true
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// enableVirtualTerminalProcessing is ENABLE_VIRTUAL_TERMINAL_PROCESSING console output mode
+const enableVirtualTerminalProcessing = 0x0004
+
+var (
+	kernel32                       = syscall.NewLazyDLL("kernel32.dll")
+	procSetConsoleMode             = kernel32.NewProc("SetConsoleMode")
+	procGetConsoleScreenBufferInfo = kernel32.NewProc("GetConsoleScreenBufferInfo")
+)
+
+// consoleScreenBufferInfo is CONSOLE_SCREEN_BUFFER_INFO
+type consoleScreenBufferInfo struct {
+	size              struct{ x, y int16 }
+	cursorPosition    struct{ x, y int16 }
+	attributes        uint16
+	window            struct{ left, top, right, bottom int16 }
+	maximumWindowSize struct{ x, y int16 }
+}
+
+// isTerminal checks if file descriptor is a console
+func isTerminal(fd int) bool {
+	var mode uint32
+	return syscall.GetConsoleMode(syscall.Handle(fd), &mode) == nil
+}
+
+// enableVirtualTerminal enables processing of ANSI escape sequences by console
+func enableVirtualTerminal(fd int) bool {
+	var mode uint32
+	if err := syscall.GetConsoleMode(syscall.Handle(fd), &mode); err != nil {
+		return false
+	}
+	if mode&enableVirtualTerminalProcessing != 0 {
+		return true
+	}
+	r, _, _ := procSetConsoleMode.Call(uintptr(fd), uintptr(mode|enableVirtualTerminalProcessing))
+	return r != 0
+}
+
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
+
+// terminalSize returns console window rows and columns
+func terminalSize(fd int) (int, int, error) {
+	var info consoleScreenBufferInfo
+	r, _, err := procGetConsoleScreenBufferInfo.Call(uintptr(fd), uintptr(unsafe.Pointer(&info)))
+	if r == 0 {
+		return 0, 0, errors.Wrap(err, "GetConsoleScreenBufferInfo failed")
+	}
+	return int(info.window.bottom-info.window.top) + 1, int(info.window.right-info.window.left) + 1, nil
+}
+
+func notifyResize(ch chan<- os.Signal) {
+}
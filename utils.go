@@ -22,3 +22,15 @@ func mergeErrors(errs ...error) error {
 	}
 	return nil
 }
+
+// stringListFlag collects values of repeated command line flag
+type stringListFlag []string
+
+func (l *stringListFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringListFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
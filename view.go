@@ -0,0 +1,708 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// viewPollInterval is how often viewer checks file for appended lines in follow mode
+const viewPollInterval = 500 * time.Millisecond
+
+const (
+	keyUp = iota + 0x110000
+	keyDown
+	keyPageUp
+	keyPageDown
+	keyHome
+	keyEnd
+	keyEscape
+)
+
+// viewRecord is a line shown in viewer list, only its position and summary are kept in memory,
+// detail is decoded on demand
+type viewRecord struct {
+	number    int64
+	offset    int64
+	size      int64
+	truncated bool
+	level     logLevel
+	requestID string
+	summary   string
+}
+
+// viewFilter is parsed filter prompt: level>=warn, key=value terms and bare text
+type viewFilter struct {
+	source string
+	fields []recordField
+	text   []string
+}
+
+// viewer is an interactive terminal viewer of decoded log file
+type viewer struct {
+	file    *os.File
+	decoder *recordDecoder
+	maxLine int
+
+	readOffset int64
+	lineNumber int64
+
+	records   []*viewRecord
+	visible   []int
+	selected  int
+	top       int
+	detailTop int
+
+	// detail is decoded text of detailRecord
+	detailRecord *viewRecord
+	detail       []string
+
+	minLevel  logLevel
+	filter    viewFilter
+	requestID string
+	follow    bool
+
+	prompt  bool
+	input   []rune
+	message string
+	rows    int
+	cols    int
+	screen  bytes.Buffer
+}
+
+func runView(args []string) {
+	flags := flag.NewFlagSet("view", flag.ExitOnError)
+	skipFields := flags.String("skip", "", "list of fields to skip from detail pane")
+	bodyFields := flags.String("bodyfields", "body_string", "list of body fields to decode by content type")
+	skipEmpty := flags.Bool("skipempty", false, "skip fields with empty values")
+	xmlView := flags.String("xmlview", "pretty", "xml body view: pretty, compact (path = value lines) or both")
+	xmlDepth := flags.Int("xmldepth", 0, "xml body view depth limit, 0 - unlimited")
+	maxLine := flags.Int("maxline", 32*1048576, "max line length, longer lines are truncated")
+	follow := flags.Bool("follow", false, "start in follow mode")
+	filter := flags.String("filter", "", "initial filter: level>=warn, key=value terms and text")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s view [flags] <file>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	mode, err := parseXMLViewMode(*xmlView)
+	if err != nil {
+		fmt.Printf("Invalid xmlview %s %s:", *xmlView, err)
+		os.Exit(1)
+	}
+	xmlRenderOptions.mode = mode
+	xmlRenderOptions.maxDepth = *xmlDepth
+
+	if !isTerminal(int(os.Stdin.Fd())) || !isTerminal(int(os.Stdout.Fd())) {
+		fmt.Fprintf(os.Stderr, "view requires a terminal\n")
+		os.Exit(1)
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Printf("Open error %s %s:", flags.Arg(0), err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	formatter := newRecordFormatter(false)
+	formatter.needColors = false
+	formatter.warnColor = ""
+	formatter.resetColor = ""
+	v := &viewer{
+		file: f,
+		decoder: &recordDecoder{
+			formatter:  formatter,
+			skipFields: splitFieldSet(*skipFields),
+			skipEmpty:  *skipEmpty,
+			bodyFields: splitFieldSet(*bodyFields),
+		},
+		maxLine:  *maxLine,
+		minLevel: logLevelTrace,
+		follow:   *follow,
+		filter:   parseViewFilter(*filter),
+	}
+	if err := v.run(); err != nil {
+		fmt.Fprintf(os.Stderr, "view error %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// splitFieldSet splits comma separated list of field names
+func splitFieldSet(s string) map[string]struct{} {
+	m := make(map[string]struct{})
+	if s != "" {
+		for _, key := range strings.Split(s, ",") {
+			m[key] = struct{}{}
+		}
+	}
+	return m
+}
+
+func (v *viewer) run() error {
+	in := int(os.Stdin.Fd())
+	restore, err := makeRaw(in)
+	if err != nil {
+		return errors.Wrap(err, "make raw terminal")
+	}
+	defer restore()
+	v.updateSize()
+
+	os.Stdout.WriteString("\x1b[?1049h\x1b[?25l")
+	defer os.Stdout.WriteString("\x1b[?25h\x1b[?1049l")
+
+	if err := v.load(); err != nil {
+		return err
+	}
+	v.applyFilter()
+	if v.follow {
+		v.selectLast()
+	}
+
+	keys := make(chan rune, 64)
+	go readKeys(os.Stdin, keys)
+	resize := make(chan os.Signal, 1)
+	notifyResize(resize)
+	ticker := time.NewTicker(viewPollInterval)
+	defer ticker.Stop()
+
+	for {
+		v.draw()
+		select {
+		case key, ok := <-keys:
+			if !ok || !v.handleKey(key) {
+				return nil
+			}
+		case <-resize:
+			v.updateSize()
+		case <-ticker.C:
+			if !v.follow {
+				continue
+			}
+			before := len(v.records)
+			if err := v.load(); err != nil {
+				v.message = err.Error()
+			}
+			if len(v.records) != before {
+				v.applyFilter()
+				v.selectLast()
+			}
+		}
+	}
+}
+
+func (v *viewer) updateSize() {
+	rows, cols, err := terminalSize(int(os.Stdout.Fd()))
+	if err != nil || rows < 6 || cols < 20 {
+		rows, cols = 24, 80
+	}
+	v.rows, v.cols = rows, cols
+}
+
+// load reads summaries of complete lines appended to file since previous call
+func (v *viewer) load() error {
+	info, err := v.file.Stat()
+	if err != nil {
+		return errors.Wrap(err, "stat")
+	}
+	size := info.Size()
+	if size < v.readOffset {
+		// file was truncated, start over
+		v.readOffset = 0
+		v.lineNumber = 0
+		v.records = nil
+		v.detailRecord = nil
+	}
+	if size == v.readOffset {
+		return nil
+	}
+	last := make([]byte, 1)
+	if _, err := v.file.ReadAt(last, size-1); err != nil {
+		return errors.Wrap(err, "read")
+	}
+	// line being appended is loaded on next call
+	complete := last[0] == '\n'
+
+	lines := newLineReader(io.NewSectionReader(v.file, v.readOffset, size-v.readOffset), v.maxLine)
+	var count, loaded int64
+	for lines.Next() {
+		line := *lines.Line()
+		if !complete && v.readOffset+line.offset+line.size == size {
+			break
+		}
+		line.number += v.lineNumber
+		line.offset += v.readOffset
+		v.records = append(v.records, newViewRecord(&line))
+		count++
+		loaded += line.size
+	}
+	v.lineNumber += count
+	v.readOffset += loaded
+	return lines.Err()
+}
+
+func newViewRecord(in *inputLine) *viewRecord {
+	r := &viewRecord{
+		number:    in.number,
+		offset:    in.offset,
+		size:      in.size,
+		truncated: in.truncated,
+		level:     logLevelWarn,
+	}
+	if in.truncated {
+		r.summary = strings.TrimSpace(truncatedPreview(in.data))
+		return r
+	}
+	record, err := parseRecord(in.data)
+	if err != nil {
+		r.summary = strings.TrimSpace(truncatedPreview(in.data))
+		return r
+	}
+	r.level = parseLogLevel(record.GetString("level"))
+	r.requestID = scalarField(record, "request_id")
+	r.summary = viewSummary(recordSummary(record))
+	return r
+}
+
+// viewSummary limits summary kept for every record, long values are copied to not keep them in memory
+func viewSummary(s string) string {
+	if len(s) <= maxTruncatedPreview {
+		return s
+	}
+	return string([]byte(s[:maxTruncatedPreview])) + "..."
+}
+
+// readLine reads line of record from file, truncated lines are read up to maxLine
+func (v *viewer) readLine(r *viewRecord) (*inputLine, error) {
+	n := r.size
+	if r.truncated && v.maxLine > 0 && int64(v.maxLine) < n {
+		n = int64(v.maxLine)
+	}
+	data := make([]byte, n)
+	if _, err := v.file.ReadAt(data, r.offset); err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+	if !r.truncated {
+		data = bytes.TrimSuffix(data, []byte("\n"))
+		data = bytes.TrimSuffix(data, []byte("\r"))
+	}
+	return &inputLine{data: data, number: r.number, offset: r.offset, size: r.size, truncated: r.truncated}, nil
+}
+
+// detailLines returns decoded text of record split into lines with expanded tabs,
+// only lines of the last shown record are cached
+func (v *viewer) detailLines(r *viewRecord) []string {
+	if v.detailRecord == r {
+		return v.detail
+	}
+	var text string
+	in, err := v.readLine(r)
+	if err != nil {
+		text = "Read error: " + err.Error()
+	} else {
+		rec := v.decoder.decode(in)
+		switch {
+		case rec.truncated:
+			text = "Line too long: " + rec.err.Error() + "\n\n" + truncatedPreview(rec.line)
+		case rec.err != nil:
+			text = "Unmarshal: " + rec.err.Error() + "\n\n" + string(rec.line)
+		default:
+			text = strings.TrimRight(rec.output.decoded.String(), "\n")
+		}
+	}
+	header := fmt.Sprintf("#%d @ 0x%x", r.number, r.offset)
+	v.detailRecord = r
+	v.detail = append([]string{header}, strings.Split(strings.Replace(text, "\t", "    ", -1), "\n")...)
+	return v.detail
+}
+
+func parseViewFilter(s string) viewFilter {
+	f := viewFilter{source: s}
+	for _, term := range strings.Fields(s) {
+		if i := strings.Index(term, "="); i > 0 {
+			f.fields = append(f.fields, recordField{key: term[:i], value: term[i+1:]})
+			continue
+		}
+		f.text = append(f.text, strings.ToLower(term))
+	}
+	return f
+}
+
+// isLevelTerm checks if filter term is level>=x, handled as minimum level
+func isLevelTerm(field recordField) bool {
+	return strings.HasSuffix(field.key, ">") && field.key[:len(field.key)-1] == "level"
+}
+
+// needsLine checks if filter matches record fields or text, not only level
+func (f *viewFilter) needsLine() bool {
+	for _, field := range f.fields {
+		if !isLevelTerm(field) {
+			return true
+		}
+	}
+	return len(f.text) > 0
+}
+
+// matches checks record and its line against filter, line is used only if needsLine
+func (f *viewFilter) matches(r *viewRecord, line []byte) bool {
+	var record *parsedRecord
+	parsed := false
+	for _, field := range f.fields {
+		want := field.value.(string)
+		if isLevelTerm(field) {
+			if r.level < parseLogLevel(want) {
+				return false
+			}
+			continue
+		}
+		if !parsed && !r.truncated {
+			record, _ = parseRecord(line)
+			parsed = true
+		}
+		if record == nil || !strings.Contains(scalarField(record, field.key), want) {
+			return false
+		}
+	}
+	if len(f.text) > 0 {
+		lower := strings.ToLower(string(line))
+		for _, text := range f.text {
+			if !strings.Contains(lower, text) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// applyFilter rebuilds visible list keeping selected record when possible,
+// lines are read again from file for field and text filters
+func (v *viewer) applyFilter() {
+	current := -1
+	if v.selected < len(v.visible) {
+		current = v.visible[v.selected]
+	}
+	v.visible = v.visible[:0]
+	v.selected = 0
+	check := func(i int, line []byte) {
+		r := v.records[i]
+		if r.level < v.minLevel || (v.requestID != "" && r.requestID != v.requestID) || !v.filter.matches(r, line) {
+			return
+		}
+		if i <= current {
+			v.selected = len(v.visible)
+		}
+		v.visible = append(v.visible, i)
+	}
+	if !v.filter.needsLine() {
+		for i := range v.records {
+			check(i, nil)
+		}
+	} else if err := v.scanLines(check); err != nil {
+		v.message = err.Error()
+	}
+	v.detailTop = 0
+}
+
+// scanLines reads loaded lines of file in order, i is index of line record
+func (v *viewer) scanLines(fn func(i int, line []byte)) error {
+	lines := newLineReader(io.NewSectionReader(v.file, 0, v.readOffset), v.maxLine)
+	for i := 0; i < len(v.records) && lines.Next(); i++ {
+		fn(i, lines.Line().data)
+	}
+	return lines.Err()
+}
+
+func (v *viewer) current() *viewRecord {
+	if v.selected < len(v.visible) {
+		return v.records[v.visible[v.selected]]
+	}
+	return nil
+}
+
+func (v *viewer) selectLast() {
+	if len(v.visible) > 0 {
+		v.selected = len(v.visible) - 1
+	}
+	v.detailTop = 0
+}
+
+func (v *viewer) move(delta int) {
+	v.selected += delta
+	if v.selected >= len(v.visible) {
+		v.selected = len(v.visible) - 1
+	}
+	if v.selected < 0 {
+		v.selected = 0
+	}
+	v.detailTop = 0
+}
+
+// jumpRequest selects next or previous visible record with the same request_id
+func (v *viewer) jumpRequest(step int) {
+	r := v.current()
+	if r == nil || r.requestID == "" {
+		v.message = "no request_id in selected record"
+		return
+	}
+	for i := v.selected + step; i >= 0 && i < len(v.visible); i += step {
+		if v.records[v.visible[i]].requestID == r.requestID {
+			v.selected = i
+			v.detailTop = 0
+			return
+		}
+	}
+	v.message = "no more records of request " + r.requestID
+}
+
+func (v *viewer) listHeight() int {
+	return (v.rows - 2) / 2
+}
+
+func (v *viewer) detailHeight() int {
+	return v.rows - 2 - v.listHeight()
+}
+
+// handleKey processes key press, returns false to quit
+func (v *viewer) handleKey(key rune) bool {
+	if v.prompt {
+		switch key {
+		case '\r', '\n':
+			v.prompt = false
+			v.filter = parseViewFilter(string(v.input))
+			v.applyFilter()
+		case keyEscape:
+			v.prompt = false
+		case 127, 8:
+			if len(v.input) > 0 {
+				v.input = v.input[:len(v.input)-1]
+			}
+		default:
+			if key >= ' ' && key < keyUp {
+				v.input = append(v.input, key)
+			}
+		}
+		return true
+	}
+
+	v.message = ""
+	page := v.listHeight() - 1
+	switch key {
+	case 'q', 3:
+		return false
+	case 'j', keyDown:
+		v.move(1)
+	case 'k', keyUp:
+		v.move(-1)
+	case ' ', keyPageDown:
+		v.move(page)
+	case 'b', keyPageUp:
+		v.move(-page)
+	case 'g', keyHome:
+		v.move(-len(v.visible))
+	case 'G', keyEnd:
+		v.selectLast()
+	case 'J':
+		if r := v.current(); r != nil && v.detailTop < len(v.detailLines(r))-v.detailHeight() {
+			v.detailTop++
+		}
+	case 'K':
+		if v.detailTop > 0 {
+			v.detailTop--
+		}
+	case 'l':
+		v.minLevel = (v.minLevel + 1) % (logLevelError + 1)
+		v.applyFilter()
+	case 'r':
+		if v.requestID != "" {
+			v.requestID = ""
+		} else if r := v.current(); r != nil && r.requestID != "" {
+			v.requestID = r.requestID
+		} else {
+			v.message = "no request_id in selected record"
+		}
+		v.applyFilter()
+	case 'n':
+		v.jumpRequest(1)
+	case 'N':
+		v.jumpRequest(-1)
+	case 'F':
+		v.follow = !v.follow
+		if v.follow {
+			if err := v.load(); err != nil {
+				v.message = err.Error()
+			}
+			v.applyFilter()
+			v.selectLast()
+		}
+	case '/':
+		v.prompt = true
+		v.input = []rune(v.filter.source)
+	case 'c':
+		v.filter = viewFilter{}
+		v.requestID = ""
+		v.minLevel = logLevelTrace
+		v.applyFilter()
+	}
+	return true
+}
+
+func (v *viewer) draw() {
+	s := &v.screen
+	s.Reset()
+	s.WriteString("\x1b[H")
+
+	height := v.listHeight()
+	if v.selected < v.top {
+		v.top = v.selected
+	}
+	if v.selected >= v.top+height {
+		v.top = v.selected - height + 1
+	}
+	for i := 0; i < height; i++ {
+		idx := v.top + i
+		if idx >= len(v.visible) {
+			v.writeLine("", "")
+			continue
+		}
+		r := v.records[v.visible[idx]]
+		style := colorOptions.theme.level(r.level)
+		if idx == v.selected {
+			style += "\x1b[7m"
+		}
+		v.writeLine(style, fmt.Sprintf("%6d %s", r.number, r.summary))
+	}
+
+	follow := ""
+	if v.follow {
+		follow = " follow"
+	}
+	status := fmt.Sprintf(" %d/%d records, level>=%s%s", len(v.visible), len(v.records), logLevelName(v.minLevel), follow)
+	if v.requestID != "" {
+		status += ", request_id=" + v.requestID
+	}
+	if v.filter.source != "" {
+		status += ", filter: " + v.filter.source
+	}
+	v.writeLine("\x1b[7m", status)
+
+	var detail []string
+	if r := v.current(); r != nil {
+		detail = v.detailLines(r)
+	}
+	for i := 0; i < v.detailHeight(); i++ {
+		if idx := v.detailTop + i; idx < len(detail) {
+			v.writeLine("", detail[idx])
+		} else {
+			v.writeLine("", "")
+		}
+	}
+
+	switch {
+	case v.prompt:
+		v.writeLastLine("", "/"+string(v.input))
+	case v.message != "":
+		v.writeLastLine(colorOptions.theme.level(logLevelWarn), v.message)
+	default:
+		v.writeLastLine("", "q quit  j/k move  J/K scroll detail  / filter  l level  r request  n/N next/prev request  F follow  c clear")
+	}
+	os.Stdout.Write(s.Bytes())
+}
+
+func (v *viewer) writeLine(style, text string) {
+	v.writeLastLine(style, text)
+	v.screen.WriteString("\r\n")
+}
+
+func (v *viewer) writeLastLine(style, text string) {
+	v.screen.WriteString(style)
+	v.screen.WriteString(fitWidth(text, v.cols))
+	v.screen.WriteString("\x1b[K\x1b[0m")
+}
+
+// fitWidth cuts text to terminal width, replacing control characters
+func fitWidth(text string, width int) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range text {
+		if n >= width {
+			break
+		}
+		if r == '\t' {
+			r = ' '
+		}
+		if r < ' ' || r == 0x7f {
+			continue
+		}
+		b.WriteRune(r)
+		n++
+	}
+	return b.String()
+}
+
+// readKeys reads terminal input and sends decoded keys, closes channel on read error
+func readKeys(r io.Reader, keys chan<- rune) {
+	defer close(keys)
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		data := buf[:n]
+		for len(data) > 0 {
+			key, size := decodeKey(data)
+			keys <- key
+			data = data[size:]
+		}
+	}
+}
+
+// decodeKey decodes one key from input, recognizing common escape sequences
+func decodeKey(data []byte) (rune, int) {
+	if data[0] != 0x1b {
+		r, size := utf8.DecodeRune(data)
+		return r, size
+	}
+	if len(data) < 3 || (data[1] != '[' && data[1] != 'O') {
+		return keyEscape, 1
+	}
+	switch data[2] {
+	case 'A':
+		return keyUp, 3
+	case 'B':
+		return keyDown, 3
+	case 'H':
+		return keyHome, 3
+	case 'F':
+		return keyEnd, 3
+	}
+	end := bytes.IndexByte(data, '~')
+	if end < 0 {
+		return keyEscape, len(data)
+	}
+	code, _ := strconv.Atoi(string(data[2:end]))
+	switch code {
+	case 1, 7:
+		return keyHome, end + 1
+	case 4, 8:
+		return keyEnd, end + 1
+	case 5:
+		return keyPageUp, end + 1
+	case 6:
+		return keyPageDown, end + 1
+	}
+	return keyEscape, end + 1
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseViewFilter(t *testing.T) {
+	f := parseViewFilter("level>=warn  request_id=r1 Timeout msg=a=b")
+	want := viewFilter{
+		source: "level>=warn  request_id=r1 Timeout msg=a=b",
+		fields: []recordField{{"level>", "warn"}, {"request_id", "r1"}, {"msg", "a=b"}},
+		text:   []string{"timeout"},
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("got %#v", f)
+	}
+	if !f.needsLine() {
+		t.Error("field filter needs line")
+	}
+	level, empty := parseViewFilter("level>=info"), parseViewFilter("")
+	if level.needsLine() || empty.needsLine() {
+		t.Error("level filter does not need line")
+	}
+	if f := parseViewFilter("=x"); len(f.fields) != 0 || !reflect.DeepEqual(f.text, []string{"=x"}) {
+		t.Errorf("term without key %#v", f)
+	}
+}
+
+const testViewLog = `{"time":"2021-06-01T10:00:00Z","level":"debug","msg":"start","request_id":"r1"}
+not json line
+{"time":"2021-06-01T10:00:01Z","level":"warn","msg":"slow Request","request_id":"r2","elapsed":1.5}
+{"time":"2021-06-01T10:00:02Z","level":"error","msg":"failed","request_id":"r1","error":"timeout"}` + "\r\n"
+
+func newTestViewer(t *testing.T, content string, maxLine int) (*viewer, string) {
+	f, err := ioutil.TempFile("", "view")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	formatter := newRecordFormatter(false)
+	formatter.needColors = false
+	v := &viewer{
+		file: f,
+		decoder: &recordDecoder{
+			formatter:  formatter,
+			skipFields: map[string]struct{}{},
+			bodyFields: map[string]struct{}{},
+		},
+		maxLine:  maxLine,
+		minLevel: logLevelTrace,
+	}
+	if err := v.load(); err != nil {
+		t.Fatal(err)
+	}
+	return v, f.Name()
+}
+
+func closeTestViewer(v *viewer, filename string) {
+	v.file.Close()
+	os.Remove(filename)
+}
+
+func visibleNumbers(v *viewer) []int64 {
+	var numbers []int64
+	for _, i := range v.visible {
+		numbers = append(numbers, v.records[i].number)
+	}
+	return numbers
+}
+
+func TestViewerLoad(t *testing.T) {
+	v, filename := newTestViewer(t, testViewLog+`{"level":"info","msg":"partial`, 0)
+	defer closeTestViewer(v, filename)
+
+	want := []viewRecord{
+		{number: 1, offset: 0, size: 80, level: logLevelDebug, requestID: "r1", summary: "2021-06-01T10:00:00Z debug start [r1]"},
+		{number: 2, offset: 80, size: 14, level: logLevelWarn, summary: "not json line"},
+		{number: 3, offset: 94, size: 100, level: logLevelWarn, requestID: "r2", summary: "2021-06-01T10:00:01Z warn slow Request [r2]"},
+		{number: 4, offset: 194, size: 100, level: logLevelError, requestID: "r1", summary: "2021-06-01T10:00:02Z error failed [r1]"},
+	}
+	if len(v.records) != len(want) {
+		t.Fatalf("loaded %d records, line being appended is loaded too", len(v.records))
+	}
+	for i := range want {
+		if *v.records[i] != want[i] {
+			t.Errorf("record %d: got %+v, want %+v", i, *v.records[i], want[i])
+		}
+	}
+
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`"}` + "\n")
+	f.Close()
+	if err := v.load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.records) != 5 || v.records[4].number != 5 || v.records[4].offset != 294 || v.records[4].summary != "info partial" {
+		t.Errorf("appended record %+v", v.records[len(v.records)-1])
+	}
+}
+
+func TestViewerApplyFilter(t *testing.T) {
+	v, filename := newTestViewer(t, testViewLog, 0)
+	defer closeTestViewer(v, filename)
+
+	tests := []struct {
+		filter    string
+		minLevel  logLevel
+		requestID string
+		want      []int64
+	}{
+		{"", logLevelTrace, "", []int64{1, 2, 3, 4}},
+		{"", logLevelWarn, "", []int64{2, 3, 4}},
+		{"level>=error", logLevelTrace, "", []int64{4}},
+		{"request_id=r1", logLevelTrace, "", []int64{1, 4}},
+		{"", logLevelTrace, "r1", []int64{1, 4}},
+		{"elapsed=1.5", logLevelTrace, "", []int64{3}},
+		{"request", logLevelTrace, "", []int64{1, 3, 4}},
+		{"json", logLevelTrace, "", []int64{2}},
+		{"TIMEOUT level>=warn", logLevelTrace, "", []int64{4}},
+		{"msg=start", logLevelTrace, "r2", nil},
+	}
+	for _, tt := range tests {
+		v.filter = parseViewFilter(tt.filter)
+		v.minLevel = tt.minLevel
+		v.requestID = tt.requestID
+		v.applyFilter()
+		if got := visibleNumbers(v); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("filter %q level %s request %q: got %v, want %v", tt.filter, logLevelName(tt.minLevel), tt.requestID, got, tt.want)
+		}
+	}
+
+	// selection stays on the same record or moves to previous visible one
+	v.filter = viewFilter{}
+	v.requestID = ""
+	v.minLevel = logLevelTrace
+	v.applyFilter()
+	v.selected = 2
+	v.filter = parseViewFilter("request_id=r1")
+	v.applyFilter()
+	if r := v.current(); r == nil || r.number != 1 {
+		t.Errorf("selected %+v", r)
+	}
+}
+
+func TestViewerDetailLines(t *testing.T) {
+	v, filename := newTestViewer(t, testViewLog+strings.Repeat("x", 200)+"\n", 128)
+	defer closeTestViewer(v, filename)
+
+	detail := v.detailLines(v.records[3])
+	if detail[0] != "#4 @ 0xc2" || !strings.Contains(strings.Join(detail, "\n"), "error: timeout") {
+		t.Errorf("detail of record 4:\n%s", strings.Join(detail, "\n"))
+	}
+	if again := v.detailLines(v.records[3]); &again[0] != &detail[0] {
+		t.Error("detail of shown record is decoded again")
+	}
+	if detail := v.detailLines(v.records[1]); detail[1] != "Unmarshal: invalid character 'o' in literal null (expecting 'u')" || detail[3] != "not json line" {
+		t.Errorf("detail of invalid line %q", detail)
+	}
+	long := v.records[4]
+	if !long.truncated || long.size != 201 {
+		t.Fatalf("long line %+v", long)
+	}
+	detail = v.detailLines(long)
+	if detail[1] != "Line too long: line of 201 bytes at offset 294 truncated to 128 bytes" || detail[3] != strings.Repeat("x", 128) {
+		t.Errorf("detail of truncated line %q", detail)
+	}
+}
+
+func TestViewSummary(t *testing.T) {
+	if s := viewSummary("short"); s != "short" {
+		t.Errorf("short summary %q", s)
+	}
+	long := strings.Repeat("a", maxTruncatedPreview+10)
+	if s := viewSummary(long); s != long[:maxTruncatedPreview]+"..." {
+		t.Errorf("long summary of %d bytes", len(s))
+	}
+}
+
+func TestDecodeKey(t *testing.T) {
+	tests := []struct {
+		in   string
+		key  rune
+		size int
+	}{
+		{"q", 'q', 1},
+		{"ж", 'ж', 2},
+		{"\x1b", keyEscape, 1},
+		{"\x1b[A", keyUp, 3},
+		{"\x1bOB", keyDown, 3},
+		{"\x1b[H", keyHome, 3},
+		{"\x1b[F", keyEnd, 3},
+		{"\x1b[5~j", keyPageUp, 4},
+		{"\x1b[6~", keyPageDown, 4},
+		{"\x1b[1~", keyHome, 4},
+		{"\x1b[8~", keyEnd, 4},
+		{"\x1b[15~", keyEscape, 5},
+		{"\x1b[99", keyEscape, 4},
+		{"\x1bx1", keyEscape, 1},
+	}
+	for _, tt := range tests {
+		if key, size := decodeKey([]byte(tt.in)); key != tt.key || size != tt.size {
+			t.Errorf("decodeKey(%q) = %d, %d, want %d, %d", tt.in, key, size, tt.key, tt.size)
+		}
+	}
+}
+
+func TestFitWidth(t *testing.T) {
+	tests := []struct {
+		in    string
+		width int
+		want  string
+	}{
+		{"hello", 10, "hello"},
+		{"hello world", 5, "hello"},
+		{"a\tb", 10, "a b"},
+		{"a\x1b[31mb\x7f", 10, "a[31mb"},
+		{"ключ значение", 4, "ключ"},
+		{"", 3, ""},
+	}
+	for _, tt := range tests {
+		if got := fitWidth(tt.in, tt.width); got != tt.want {
+			t.Errorf("fitWidth(%q, %d) = %q, want %q", tt.in, tt.width, got, tt.want)
+		}
+	}
+}
@@ -2,30 +2,50 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
+	"bytes"
 	"fmt"
+	"html"
 	"io"
 	"os"
-	"runtime"
-	"strings"
+	"path/filepath"
 
 	"github.com/pkg/errors"
 )
 
 // logWriter contains all output writers for decoded logs
 type logWriter struct {
-	needColors        bool
-	warnColor         string
-	resetColor        string
-	hideDebug         bool
+	recordFormatter
 	bufferSize        int
 	decodedWriter     io.WriteCloser
 	decodedInfoWriter io.WriteCloser
 	originalWriter    io.WriteCloser
 	errorWriter       io.WriteCloser
+	indexWriter       io.WriteCloser
+	htmlWriter        io.WriteCloser
+	jsonlWriter       io.WriteCloser
+	originalOffset    int64
+	paths             writerPaths
+	suspended         bool
+}
+
+// writerPaths are filenames of logWriter sinks, empty filename disables sink
+type writerPaths struct {
+	decoded  string
+	info     string
+	errors   string
+	original string
+	index    string
+	html     string
+	jsonl    string
 }
 
 func (w *logWriter) Close() error {
+	if w.suspended {
+		// reopen to finish files written on close, e.g. html report footer
+		if err := w.Resume(); err != nil {
+			return err
+		}
+	}
 	var errDecodedWriter error
 	if w.decodedWriter != nil {
 		errDecodedWriter = w.decodedWriter.Close()
@@ -42,22 +62,24 @@ func (w *logWriter) Close() error {
 	if w.errorWriter != nil {
 		errErrorWriter = w.errorWriter.Close()
 	}
-	return mergeErrors(errDecodedWriter, errDecodedInfoWriter, errOriginalWriter, errErrorWriter)
+	var errIndexWriter error
+	if w.indexWriter != nil {
+		errIndexWriter = w.indexWriter.Close()
+	}
+	var errHTMLWriter error
+	if w.htmlWriter != nil {
+		errHTMLWriter = w.htmlWriter.Close()
+	}
+	var errJSONLWriter error
+	if w.jsonlWriter != nil {
+		errJSONLWriter = w.jsonlWriter.Close()
+	}
+	return mergeErrors(errDecodedWriter, errDecodedInfoWriter, errOriginalWriter, errErrorWriter, errIndexWriter, errHTMLWriter, errJSONLWriter)
 }
 
 func newWriter(hideDebug bool) *logWriter {
-	needColors := runtime.GOOS == "linux" || runtime.GOOS == "darwin"
-	warnColor := ""
-	resetColor := ""
-	if needColors {
-		warnColor = levelToColor(logLevelWarn)
-		resetColor = "\u001b[0m"
-	}
 	return &logWriter{
-		needColors: needColors,
-		warnColor:  warnColor,
-		resetColor: resetColor,
-		hideDebug:  hideDebug,
+		recordFormatter: *newRecordFormatter(hideDebug),
 	}
 }
 
@@ -108,80 +130,178 @@ func openLogFile(filename string, bufferSize int, fileRef *io.WriteCloser) error
 	return nil
 }
 
+// openRotatingLogFile opens file rotated by -rotate* flags, or appended forever when rotation is disabled
+func openRotatingLogFile(filename string, bufferSize int, fileRef *io.WriteCloser) error {
+	if !logRotation.enabled() {
+		return openLogFile(filename, bufferSize, fileRef)
+	}
+	r, err := openRotatingFile(filename, bufferSize, logRotation)
+	if err != nil {
+		return err
+	}
+	*fileRef = r
+	return nil
+}
+
 func (w *logWriter) OpenDecoded(filename string) error {
-	return openLogFile(filename, w.bufferSize, &w.decodedWriter)
+	return openRotatingLogFile(filename, w.bufferSize, &w.decodedWriter)
 }
 
 func (w *logWriter) OpenDecodedInfo(filename string) error {
-	return openLogFile(filename, w.bufferSize, &w.decodedInfoWriter)
+	return openRotatingLogFile(filename, w.bufferSize, &w.decodedInfoWriter)
 }
 
 func (w *logWriter) OpenError(filename string) error {
-	return openLogFile(filename, w.bufferSize, &w.errorWriter)
+	return openRotatingLogFile(filename, w.bufferSize, &w.errorWriter)
 }
 
 func (w *logWriter) OpenOriginal(filename string) error {
-	return openLogFile(filename, w.bufferSize, &w.originalWriter)
+	err := openRotatingLogFile(filename, w.bufferSize, &w.originalWriter)
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(filename); err == nil {
+		w.originalOffset = fi.Size()
+	}
+	return nil
+}
+
+// OpenOriginalIndex opens index of record numbers, input offsets and offsets in original log.
+// Index of rotated original log is rotated together with it
+func (w *logWriter) OpenOriginalIndex(filename string) error {
+	original, ok := w.originalWriter.(*rotatingFile)
+	if !ok {
+		return openLogFile(filename, w.bufferSize, &w.indexWriter)
+	}
+	opts := original.opts
+	opts.maxSize = 0
+	opts.interval = 0
+	index, err := openRotatingFile(filename, w.bufferSize, opts)
+	if err != nil {
+		return err
+	}
+	original.onRotate = index.Rotate
+	w.indexWriter = index
+	return nil
+}
+
+// OpenJSONL opens sink of normalized records in json lines format
+func (w *logWriter) OpenJSONL(filename string) error {
+	return openRotatingLogFile(filename, w.bufferSize, &w.jsonlWriter)
 }
 
 func (w *logWriter) OpenAll(decodedFilename, decodedInfoFilename, errorFilename, originalFilename string) error {
-	if decodedFilename != "" {
-		err := w.OpenDecoded(decodedFilename)
-		if err != nil {
-			return err
-		}
+	return w.Open(writerPaths{
+		decoded:  decodedFilename,
+		info:     decodedInfoFilename,
+		errors:   errorFilename,
+		original: originalFilename,
+	})
+}
+
+func (w *logWriter) OpenWithPrefix(prefix string) error {
+	return w.Open(prefixWriterPaths(prefix))
+}
+
+// prefixWriterPaths returns names of decoded, info, error and original logs with prefix
+func prefixWriterPaths(prefix string) writerPaths {
+	return writerPaths{
+		decoded:  fmt.Sprintf("%s_log_decoded.log", prefix),
+		info:     fmt.Sprintf("%s_log_info.log", prefix),
+		errors:   fmt.Sprintf("%s_log_error.log", prefix),
+		original: fmt.Sprintf("%s_log_original.log", prefix),
 	}
-	if decodedInfoFilename != "" {
-		err := w.OpenDecoded(decodedInfoFilename)
-		if err != nil {
-			return err
+}
+
+// Open opens all sinks with not empty filenames, creating missing directories
+func (w *logWriter) Open(paths writerPaths) error {
+	w.paths = paths
+	for _, open := range []struct {
+		filename string
+		open     func(string) error
+	}{
+		{paths.decoded, w.OpenDecoded},
+		{paths.info, w.OpenDecodedInfo},
+		{paths.errors, w.OpenError},
+		{paths.original, w.OpenOriginal},
+		{paths.index, w.OpenOriginalIndex},
+		{paths.html, w.OpenHTML},
+		{paths.jsonl, w.OpenJSONL},
+	} {
+		if open.filename == "" {
+			continue
 		}
-	}
-	if errorFilename != "" {
-		err := w.OpenError(errorFilename)
-		if err != nil {
-			return err
+		if dir := filepath.Dir(open.filename); dir != "." {
+			if err := os.MkdirAll(dir, 0770); err != nil {
+				return errors.Wrapf(err, "MkdirAll %s failed", dir)
+			}
 		}
-	}
-	if originalFilename != "" {
-		err := w.OpenOriginal(originalFilename)
-		if err != nil {
+		if err := open.open(open.filename); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (w *logWriter) OpenWithPrefix(prefix string) error {
-	err := w.OpenDecoded(fmt.Sprintf("%s_log_decoded.log", prefix))
-	if err != nil {
-		return err
-	}
-	err = w.OpenDecodedInfo(fmt.Sprintf("%s_log_info.log", prefix))
-	if err != nil {
-		return err
-	}
-	err = w.OpenError(fmt.Sprintf("%s_log_error.log", prefix))
-	if err != nil {
-		return err
-	}
-	err = w.OpenOriginal(fmt.Sprintf("%s_log_original.log", prefix))
-	if err != nil {
-		return err
-	}
-	return nil
+// Suspend closes all sinks to release file descriptors, Resume reopens them for append
+func (w *logWriter) Suspend() error {
+	if h, ok := w.htmlWriter.(*htmlWriteCloser); ok {
+		// footer is written only on final Close
+		w.htmlWriter = h.WriteCloser
+	}
+	err := w.Close()
+	w.decodedWriter = nil
+	w.decodedInfoWriter = nil
+	w.originalWriter = nil
+	w.errorWriter = nil
+	w.indexWriter = nil
+	w.htmlWriter = nil
+	w.jsonlWriter = nil
+	w.suspended = true
+	return err
 }
 
-func (w *logWriter) WriteOriginal(b []byte) {
+func (w *logWriter) Resume() error {
+	err := w.Open(w.paths)
+	w.suspended = false
+	return err
+}
+
+func (w *logWriter) WriteOriginal(number, offset int64, b []byte) {
 	if w.originalWriter == nil {
 		return
 	}
+	if r, ok := w.originalWriter.(*rotatingFile); ok {
+		// rotate before index line is written, offsets are relative to current original file
+		r.RotateBefore(int64(len(b)) + 1)
+		w.originalOffset = r.size
+	}
+	if w.indexWriter != nil {
+		_, err := fmt.Fprintf(w.indexWriter, "%d\t%d\t%d\n", number, offset, w.originalOffset)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WriteOriginal index error %s\n", err)
+		}
+	}
 	_, err := w.originalWriter.Write(b)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "WriteOriginal error %s\n", err)
 	}
 	fmt.Fprintf(w.originalWriter, "\n")
+	w.originalOffset += int64(len(b)) + 1
+}
 
+// WriteRecordHeader writes `#number @ 0xoffset` line to decoded sinks receiving record of level
+func (w *logWriter) WriteRecordHeader(number, offset int64, level logLevel) {
+	header := fmt.Sprintf("#%d @ 0x%x\n", number, offset)
+	if w.decodedWriter != nil {
+		io.WriteString(w.decodedWriter, header)
+	}
+	if level.IsInfoOrHigher() && w.decodedInfoWriter != nil {
+		io.WriteString(w.decodedInfoWriter, header)
+	}
+	if level.IsErrorOrWarn() && w.errorWriter != nil {
+		io.WriteString(w.errorWriter, header)
+	}
 }
 
 func (w *logWriter) WriteText(text string) {
@@ -189,6 +309,9 @@ func (w *logWriter) WriteText(text string) {
 	if w.decodedWriter != nil {
 		fmt.Fprintf(w.decodedWriter, "%s\n", text)
 	}
+	if w.htmlWriter != nil {
+		w.writeHTMLCard(logLevelWarn, "", text, "<pre>"+html.EscapeString(text)+"</pre>\n")
+	}
 }
 
 func (w *logWriter) WriteTextAndError(comment, text string, err error) {
@@ -196,90 +319,33 @@ func (w *logWriter) WriteTextAndError(comment, text string, err error) {
 	if w.decodedWriter != nil {
 		fmt.Fprintf(w.decodedWriter, "%s error %s\n%s\n", comment, err, text)
 	}
-}
-
-func (w *logWriter) WriteIface(level logLevel, name string, value interface{}) {
-	b, err := json.MarshalIndent(value, "", "  ")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Marshal error %s\n", err)
-		return
-	}
-	if !w.hideDebug || level.IsInfoOrHigher() {
-		color := ""
-		if w.needColors {
-			color = levelToColor(level)
-		}
-		fmt.Printf("%s%s: %s%s\n", color, name, string(b), w.resetColor)
-	}
-	if w.decodedWriter != nil {
-		_, err := fmt.Fprintf(w.decodedWriter, "%s: %s\n", name, string(b))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "WriteIface: error write %s\n", err)
-		}
-	}
-	if level.IsInfoOrHigher() && w.decodedInfoWriter != nil {
-		_, err := fmt.Fprintf(w.decodedInfoWriter, "%s: %s\n", name, string(b))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "WriteIface: error write %s\n", err)
-		}
-	}
-	if level.IsErrorOrWarn() && w.errorWriter != nil {
-		_, err := fmt.Fprintf(w.errorWriter, "%s: %s\n", name, string(b))
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "WriteIface: error write %s\n", err)
-		}
+	if w.htmlWriter != nil {
+		title := fmt.Sprintf("%s error %s", comment, err)
+		w.writeHTMLCard(logLevelWarn, "", title, "<pre>"+html.EscapeString(title+"\n"+text)+"</pre>\n")
 	}
 }
 
-func (w *logWriter) WriteValue(level logLevel, name string, value interface{}) {
-	s := fmt.Sprintf("%+v", value)
-	// s = strings.TrimSpace(s)
-	// s = strings.Replace(s, "\n\n", "\\n\n", -1)
-	// s = strings.Replace(s, "\r\n\r\n", "\\r\\n\n", -1)
-	if strings.Contains(s, "\n") {
-		s = strings.Replace(s, "\n", "\n\t\t", -1)
-		s = fmt.Sprintf("| \n\t\t%s", s)
-	}
-
-	if !w.hideDebug || level.IsInfoOrHigher() {
-		color := ""
-		if w.needColors {
-			color = levelToColor(level)
-		}
-
-		fmt.Printf("%s%s: %s%s\n", color, name, s, w.resetColor)
-	}
-	if w.decodedWriter != nil {
-		_, err := fmt.Fprintf(w.decodedWriter, "%s: %s\n", name, s)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error write %s\n", err)
+// WriteRecord writes formatted record to stdout and decoded sinks
+func (w *logWriter) WriteRecord(r *formattedRecord) {
+	if r.stdout.Len() > 0 {
+		if _, err := os.Stdout.Write(r.stdout.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "WriteRecord: error write stdout %s\n", err)
 		}
 	}
-	if level.IsInfoOrHigher() && w.decodedInfoWriter != nil {
-		_, err := fmt.Fprintf(w.decodedInfoWriter, "%s: %s\n", name, s)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error write %s\n", err)
-		}
-	}
-	if level.IsErrorOrWarn() && w.errorWriter != nil {
-		_, err := fmt.Fprintf(w.errorWriter, "%s: %s\n", name, s)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error write %s\n", err)
-		}
+	writeSink(w.decodedWriter, &r.decoded)
+	writeSink(w.decodedInfoWriter, &r.info)
+	writeSink(w.errorWriter, &r.errors)
+	writeSink(w.jsonlWriter, &r.jsonl)
+	if r.htmlReport {
+		w.writeHTMLCard(r.level, r.requestID, r.title, r.html.String())
 	}
 }
 
-func (w *logWriter) WriteNewLine(level logLevel) {
-	if !w.hideDebug || level.IsInfoOrHigher() {
-		fmt.Println()
-	}
-	if w.decodedWriter != nil {
-		fmt.Fprintf(w.decodedWriter, "\n\n")
-	}
-	if level.IsInfoOrHigher() && w.decodedInfoWriter != nil {
-		fmt.Fprintf(w.decodedInfoWriter, "\n\n")
+func writeSink(wr io.Writer, b *bytes.Buffer) {
+	if wr == nil || b.Len() == 0 {
+		return
 	}
-	if level.IsErrorOrWarn() && w.errorWriter != nil {
-		fmt.Fprintf(w.errorWriter, "\n\n")
+	if _, err := wr.Write(b.Bytes()); err != nil {
+		fmt.Fprintf(os.Stderr, "WriteRecord: error write %s\n", err)
 	}
 }
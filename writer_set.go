@@ -0,0 +1,168 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// maxWriterNameLength limits sanitized writer name, longer names are cut and suffixed by hash
+const maxWriterNameLength = 64
+
+// defaultWriterName is {value} of default writer in templated paths, sanitizeWriterName
+// replaces `@` so no field value is expanded to the same paths
+const defaultWriterName = "@default"
+
+// writerSet contains writers selected by -writername field value. Only maxOpen recently used
+// writers keep files open, others are suspended and reopened on demand. Values above
+// maxWriters distinct names are written by default writer
+type writerSet struct {
+	defaultWriter *logWriter
+	create        func(name string) *logWriter
+	maxOpen       int
+	maxWriters    int
+	writers       map[string]*namedWriter
+	lru           *list.List
+	overflow      bool
+}
+
+// namedWriter is a writer of writerSet, elem is nil for suspended writer
+type namedWriter struct {
+	name   string
+	writer *logWriter
+	elem   *list.Element
+}
+
+func newWriterSet(defaultWriter *logWriter, create func(name string) *logWriter, maxOpen, maxWriters int) *writerSet {
+	return &writerSet{
+		defaultWriter: defaultWriter,
+		create:        create,
+		maxOpen:       maxOpen,
+		maxWriters:    maxWriters,
+		writers:       make(map[string]*namedWriter),
+		lru:           list.New(),
+	}
+}
+
+// Get returns open writer for field value
+func (s *writerSet) Get(value string) *logWriter {
+	name := sanitizeWriterName(value)
+	if name == "" {
+		return s.defaultWriter
+	}
+	nw, ok := s.writers[name]
+	switch {
+	case !ok:
+		if s.maxWriters > 0 && len(s.writers) >= s.maxWriters {
+			if !s.overflow {
+				fmt.Fprintf(os.Stderr, "writers limit %d reached, %s and other new values are written to default writer\n", s.maxWriters, name)
+				s.overflow = true
+			}
+			return s.defaultWriter
+		}
+		nw = &namedWriter{name: name, writer: s.create(name)}
+		s.writers[name] = nw
+		nw.elem = s.lru.PushFront(nw)
+	case nw.elem == nil:
+		if err := nw.writer.Resume(); err != nil {
+			fmt.Fprintf(os.Stderr, "Resume writer %s error %s\n", name, err)
+			return s.defaultWriter
+		}
+		nw.elem = s.lru.PushFront(nw)
+	default:
+		s.lru.MoveToFront(nw.elem)
+	}
+	s.evict()
+	return nw.writer
+}
+
+// evict suspends least recently used writers above maxOpen limit
+func (s *writerSet) evict() {
+	for s.maxOpen > 0 && s.lru.Len() > s.maxOpen {
+		nw := s.lru.Remove(s.lru.Back()).(*namedWriter)
+		nw.elem = nil
+		if err := nw.writer.Suspend(); err != nil {
+			fmt.Fprintf(os.Stderr, "Suspend writer %s error %s\n", nw.name, err)
+		}
+	}
+}
+
+// Close closes all writers including default one
+func (s *writerSet) Close() {
+	for _, nw := range s.writers {
+		if err := nw.writer.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Close writer %s error %s\n", nw.name, err)
+		}
+	}
+	if err := s.defaultWriter.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Close writer error %s\n", err)
+	}
+}
+
+// sanitizeWriterName makes field value safe to use as a single path element:
+// characters other than letters, digits, `-`, `_` and `.` are replaced by `_`
+func sanitizeWriterName(value string) string {
+	if value == "" {
+		return ""
+	}
+	name := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.') {
+			return r
+		}
+		return '_'
+	}, value)
+	if strings.Trim(name, ".") == "" {
+		name = strings.Replace(name, ".", "_", -1)
+	}
+	if len(name) > maxWriterNameLength || name != value {
+		// different values may give the same sanitized name, hash keeps them apart
+		sum := sha256.Sum256([]byte(value))
+		suffix := "_" + hex.EncodeToString(sum[:4])
+		if len(name) > maxWriterNameLength-len(suffix) {
+			name = name[:maxWriterNameLength-len(suffix)]
+		}
+		name += suffix
+	}
+	return name
+}
+
+// expandWriterPath expands {prefix}, {value} and {kind} placeholders of -writerpath template
+func expandWriterPath(template, prefix, value, kind string) string {
+	return strings.NewReplacer("{prefix}", prefix, "{value}", value, "{kind}", kind).Replace(template)
+}
+
+// checkWriterPath rejects -writerpath template that gives the same paths to different kinds or values
+func checkWriterPath(template string) error {
+	for _, placeholder := range []string{"{kind}", "{value}"} {
+		if !strings.Contains(template, placeholder) {
+			return errors.Errorf("template has no %s placeholder", placeholder)
+		}
+	}
+	return nil
+}
+
+// templateWriterPaths returns paths of writer sinks for -writerpath template
+func templateWriterPaths(template, prefix, value string, index, html, jsonl bool) writerPaths {
+	paths := writerPaths{
+		decoded:  expandWriterPath(template, prefix, value, "decoded"),
+		info:     expandWriterPath(template, prefix, value, "info"),
+		errors:   expandWriterPath(template, prefix, value, "error"),
+		original: expandWriterPath(template, prefix, value, "original"),
+	}
+	if index {
+		paths.index = expandWriterPath(template, prefix, value, "index")
+	}
+	if html {
+		paths.html = expandWriterPath(template, prefix, value, "html")
+	}
+	if jsonl {
+		paths.jsonl = expandWriterPath(template, prefix, value, "records")
+	}
+	return paths
+}
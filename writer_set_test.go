@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeWriterName(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"host-1.example", "host-1.example"},
+		{"_default", "_default"},
+		{"a/b", "a_b_"},
+		{"..", "___"},
+		{strings.Repeat("x", 100), strings.Repeat("x", maxWriterNameLength-9) + "_"},
+	}
+	for _, tt := range tests {
+		got := sanitizeWriterName(tt.value)
+		if tt.want == tt.value && got != tt.want || !strings.HasPrefix(got, tt.want) || len(got) > maxWriterNameLength {
+			t.Errorf("sanitizeWriterName(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+	if sanitizeWriterName("a/b") == sanitizeWriterName("a_b") {
+		t.Error("values with the same sanitized name are not kept apart")
+	}
+	for _, value := range []string{defaultWriterName, "_default", "@default", "default"} {
+		if sanitizeWriterName(value) == defaultWriterName {
+			t.Errorf("value %q is expanded to paths of default writer", value)
+		}
+	}
+}
+
+func TestCheckWriterPath(t *testing.T) {
+	tests := []struct {
+		template string
+		valid    bool
+	}{
+		{"{prefix}/{value}/{kind}.log", true},
+		{"{value}_{kind}", true},
+		{"{prefix}/{value}.log", false},
+		{"{prefix}/{kind}.log", false},
+		{"out.log", false},
+	}
+	for _, tt := range tests {
+		if err := checkWriterPath(tt.template); (err == nil) != tt.valid {
+			t.Errorf("checkWriterPath(%q) = %v", tt.template, err)
+		}
+	}
+}
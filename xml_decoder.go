@@ -37,6 +37,100 @@ func decodeXML(data string) (*Node, error) {
 	return &n, nil
 }
 
+// xmlNode converts raw tree into Node, resolving namespace prefixes the way xml.Decoder does
+func (n *rawXMLNode) xmlNode() *Node {
+	node := n.resolve(map[string]string{})
+	return &node
+}
+
+func (n *rawXMLNode) resolve(ns map[string]string) Node {
+	scoped := false
+	for _, attr := range n.attrs {
+		if attr.Name.Space != "xmlns" && (attr.Name.Space != "" || attr.Name.Local != "xmlns") {
+			continue
+		}
+		if !scoped {
+			parent := ns
+			ns = make(map[string]string, len(parent)+1)
+			for k, v := range parent {
+				ns[k] = v
+			}
+			scoped = true
+		}
+		if attr.Name.Space == "xmlns" {
+			ns[attr.Name.Local] = attr.Value
+		} else {
+			ns[""] = attr.Value
+		}
+	}
+	node := Node{XMLName: resolveXMLName(n.name, ns, true), CharData: n.text.String()}
+	if len(n.attrs) > 0 {
+		node.Attributes = make([]xml.Attr, len(n.attrs))
+		for i, attr := range n.attrs {
+			node.Attributes[i] = xml.Attr{Name: resolveXMLName(attr.Name, ns, false), Value: attr.Value}
+		}
+	}
+	if len(n.children) > 0 {
+		node.Nodes = make([]Node, len(n.children))
+		for i, child := range n.children {
+			node.Nodes[i] = child.resolve(ns)
+		}
+	}
+	return node
+}
+
+// resolveXMLName follows name translation of xml.Decoder.Token
+func resolveXMLName(n xml.Name, ns map[string]string, isElementName bool) xml.Name {
+	switch {
+	case n.Space == "xmlns":
+		return n
+	case n.Space == "" && !isElementName:
+		return n
+	case n.Space == "xml":
+		n.Space = "http://www.w3.org/XML/1998/namespace"
+	case n.Space == "" && n.Local == "xmlns":
+		return n
+	}
+	if v, ok := ns[n.Space]; ok {
+		n.Space = v
+	}
+	return n
+}
+
+// nodePath returns descendants matching path of local names, like "a>b" tags of xml.Unmarshal
+func nodePath(n *Node, path ...string) []*Node {
+	if len(path) == 0 {
+		return []*Node{n}
+	}
+	var found []*Node
+	for i := range n.Nodes {
+		if n.Nodes[i].XMLName.Local == path[0] {
+			found = append(found, nodePath(&n.Nodes[i], path[1:]...)...)
+		}
+	}
+	return found
+}
+
+// nodeAttr returns value of attribute by local name
+func nodeAttr(n *Node, name string) string {
+	for _, attr := range n.Attributes {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// nodeChild returns first child node by local name
+func nodeChild(n *Node, local string) *Node {
+	for i := range n.Nodes {
+		if n.Nodes[i].XMLName.Local == local {
+			return &n.Nodes[i]
+		}
+	}
+	return nil
+}
+
 // func main() {
 //
 
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// xmlQuery is a compiled XPath-like path: //Header/Action, //Stream[@Name='stdout']/@CommandId
+type xmlQuery struct {
+	source string
+	steps  []xmlQueryStep
+	attr   string
+	text   bool
+}
+
+// xmlQueryStep matches element by local name or `*` with predicates
+type xmlQueryStep struct {
+	descendant bool
+	name       string
+	predicates []xmlPredicate
+}
+
+// xmlPredicate is [n], [@attr], [@attr='v'], [child='v'] or [text()='v']
+type xmlPredicate struct {
+	index    int
+	attr     string
+	child    string
+	text     bool
+	value    string
+	hasValue bool
+}
+
+// xmlExtract is parsed `-xml-extract name=path` flag value
+type xmlExtract struct {
+	name  string
+	query *xmlQuery
+}
+
+func parseXMLExtract(s string) (*xmlExtract, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return nil, errors.Errorf("invalid xml extract %s, expected name=path", s)
+	}
+	q, err := compileXMLQuery(s[i+1:])
+	if err != nil {
+		return nil, err
+	}
+	return &xmlExtract{name: s[:i], query: q}, nil
+}
+
+// compileXMLQuery parses path expression
+func compileXMLQuery(path string) (*xmlQuery, error) {
+	q := &xmlQuery{source: path}
+	if !strings.HasPrefix(path, "/") {
+		path = "//" + path
+	}
+	for len(path) > 0 {
+		descendant := false
+		switch {
+		case strings.HasPrefix(path, "//"):
+			descendant = true
+			path = path[2:]
+		case strings.HasPrefix(path, "/"):
+			path = path[1:]
+		default:
+			return nil, errors.Errorf("invalid xml query %s: expected / at %s", q.source, path)
+		}
+		end := xmlQueryStepEnd(path)
+		step := path[:end]
+		path = path[end:]
+		switch {
+		case step == "":
+			return nil, errors.Errorf("invalid xml query %s: empty step", q.source)
+		case step == "text()":
+			if path != "" {
+				return nil, errors.Errorf("invalid xml query %s: text() must be last step", q.source)
+			}
+			q.text = true
+		case strings.HasPrefix(step, "@"):
+			if path != "" {
+				return nil, errors.Errorf("invalid xml query %s: attribute must be last step", q.source)
+			}
+			q.attr = localXMLName(step[1:])
+		default:
+			s, err := parseXMLQueryStep(step)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid xml query %s", q.source)
+			}
+			s.descendant = descendant
+			q.steps = append(q.steps, s)
+		}
+	}
+	if len(q.steps) == 0 {
+		return nil, errors.Errorf("invalid xml query %s: no element steps", q.source)
+	}
+	return q, nil
+}
+
+// xmlQueryStepEnd finds next / outside of predicate brackets and quotes
+func xmlQueryStepEnd(path string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == '/' && depth == 0:
+			return i
+		}
+	}
+	return len(path)
+}
+
+func localXMLName(name string) string {
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+func parseXMLQueryStep(step string) (xmlQueryStep, error) {
+	var s xmlQueryStep
+	i := strings.Index(step, "[")
+	if i < 0 {
+		s.name = localXMLName(step)
+		return s, nil
+	}
+	s.name = localXMLName(step[:i])
+	rest := step[i:]
+	for rest != "" {
+		if !strings.HasPrefix(rest, "[") {
+			return s, errors.Errorf("expected [ at %s", rest)
+		}
+		closing := xmlPredicateEnd(rest)
+		if closing < 0 {
+			return s, errors.Errorf("unclosed predicate %s", rest)
+		}
+		p, err := parseXMLPredicate(strings.TrimSpace(rest[1:closing]))
+		if err != nil {
+			return s, err
+		}
+		s.predicates = append(s.predicates, p)
+		rest = rest[closing+1:]
+	}
+	return s, nil
+}
+
+// xmlPredicateEnd finds ] closing predicate, skipping quoted values
+func xmlPredicateEnd(s string) int {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+func parseXMLPredicate(expr string) (xmlPredicate, error) {
+	var p xmlPredicate
+	if index, err := strconv.Atoi(expr); err == nil {
+		if index < 1 {
+			return p, errors.Errorf("invalid index %s", expr)
+		}
+		p.index = index
+		return p, nil
+	}
+	left := expr
+	if i := strings.Index(expr, "="); i >= 0 {
+		left = strings.TrimSpace(expr[:i])
+		value := strings.TrimSpace(expr[i+1:])
+		if len(value) < 2 || (value[0] != '\'' && value[0] != '"') || value[len(value)-1] != value[0] {
+			return p, errors.Errorf("expected quoted value in %s", expr)
+		}
+		p.value = value[1 : len(value)-1]
+		p.hasValue = true
+	}
+	switch {
+	case left == "text()":
+		p.text = true
+	case strings.HasPrefix(left, "@"):
+		p.attr = localXMLName(left[1:])
+	case left != "":
+		p.child = localXMLName(left)
+	default:
+		return p, errors.Errorf("invalid predicate %s", expr)
+	}
+	return p, nil
+}
+
+// Select returns string values of matched elements or attributes
+func (q *xmlQuery) Select(root *Node) []string {
+	document := &Node{Nodes: []Node{*root}}
+	context := []*Node{document}
+	for _, step := range q.steps {
+		var next []*Node
+		seen := make(map[*Node]struct{})
+		for _, n := range context {
+			for _, candidate := range step.match(n) {
+				if _, ok := seen[candidate]; ok {
+					continue
+				}
+				seen[candidate] = struct{}{}
+				next = append(next, candidate)
+			}
+		}
+		context = next
+	}
+
+	var result []string
+	for _, n := range context {
+		switch {
+		case q.attr != "":
+			for _, attr := range n.Attributes {
+				if attr.Name.Local == q.attr {
+					result = append(result, attr.Value)
+				}
+			}
+		case q.text:
+			result = append(result, n.CharData)
+		default:
+			result = append(result, strings.TrimSpace(nodeText(n)))
+		}
+	}
+	return result
+}
+
+// match returns children (or descendants) of n matching step in document order.
+// Predicates are applied to children of each parent separately, so [n] is n-th matching child
+// of its parent as in XPath: //Stream[1] selects first Stream of every parent, not (//Stream)[1]
+func (s *xmlQueryStep) match(n *Node) []*Node {
+	var result []*Node
+	var walk func(parent *Node)
+	walk = func(parent *Node) {
+		var candidates []*Node
+		for i := range parent.Nodes {
+			child := &parent.Nodes[i]
+			if s.name == "*" || child.XMLName.Local == s.name {
+				candidates = append(candidates, child)
+			}
+		}
+		selected := make(map[*Node]struct{})
+		for _, c := range s.filter(candidates) {
+			selected[c] = struct{}{}
+		}
+		for i := range parent.Nodes {
+			child := &parent.Nodes[i]
+			if _, ok := selected[child]; ok {
+				result = append(result, child)
+			}
+			if s.descendant {
+				walk(child)
+			}
+		}
+	}
+	walk(n)
+	return result
+}
+
+// filter applies predicates in order to children of the same parent
+func (s *xmlQueryStep) filter(candidates []*Node) []*Node {
+	for _, p := range s.predicates {
+		if p.index > 0 {
+			if p.index > len(candidates) {
+				return nil
+			}
+			candidates = candidates[p.index-1 : p.index]
+			continue
+		}
+		filtered := candidates[:0]
+		for _, c := range candidates {
+			if p.matches(c) {
+				filtered = append(filtered, c)
+			}
+		}
+		candidates = filtered
+	}
+	return candidates
+}
+
+func (p *xmlPredicate) matches(n *Node) bool {
+	switch {
+	case p.attr != "":
+		for _, attr := range n.Attributes {
+			if attr.Name.Local == p.attr && (!p.hasValue || attr.Value == p.value) {
+				return true
+			}
+		}
+		return false
+	case p.text:
+		return !p.hasValue || strings.TrimSpace(n.CharData) == p.value
+	default:
+		for i := range n.Nodes {
+			child := &n.Nodes[i]
+			if child.XMLName.Local == p.child && (!p.hasValue || strings.TrimSpace(nodeText(child)) == p.value) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// nodeText returns concatenated text of node and its descendants
+func nodeText(n *Node) string {
+	if len(n.Nodes) == 0 {
+		return n.CharData
+	}
+	var b strings.Builder
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		b.WriteString(n.CharData)
+		for i := range n.Nodes {
+			walk(&n.Nodes[i])
+		}
+	}
+	walk(n)
+	return b.String()
+}
+
+// extractXMLFields adds values selected from xml body fields as top-level record fields
+func extractXMLFields(record *parsedRecord, bodyFields map[string]struct{}, extracts []*xmlExtract) {
+	if len(extracts) == 0 {
+		return
+	}
+	// fields are visited in sorted order, so extracted fields do not depend on map iteration
+	fields := make([]string, 0, len(bodyFields))
+	for field := range bodyFields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		body, ok := record.Get(field).(string)
+		if !ok || !sniffXMLBody(body) {
+			continue
+		}
+		n, err := decodeXML(body)
+		if err != nil {
+			continue
+		}
+		for _, e := range extracts {
+			values := e.query.Select(n)
+			switch len(values) {
+			case 0:
+			case 1:
+				record.Set(e.name, values[0])
+			default:
+				list := make([]interface{}, len(values))
+				for i, v := range values {
+					list[i] = v
+				}
+				record.Set(e.name, list)
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testXMLQueryDocument = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell">
+  <s:Header>
+    <a:Action>http://schemas.microsoft.com/wbem/wsman/1/windows/shell/ReceiveResponse</a:Action>
+    <a:MessageID>uuid:1</a:MessageID>
+  </s:Header>
+  <s:Body>
+    <rsp:ReceiveResponse>
+      <rsp:Stream Name="stdout" CommandId="c1">b3V0MQ==</rsp:Stream>
+      <rsp:Stream Name="stdout" CommandId="c1">b3V0Mg==</rsp:Stream>
+      <rsp:Stream Name="stderr" CommandId="c1">ZXJy</rsp:Stream>
+      <rsp:CommandState CommandId="c1" State="Done"><rsp:ExitCode>0</rsp:ExitCode></rsp:CommandState>
+    </rsp:ReceiveResponse>
+    <rsp:ReceiveResponse>
+      <rsp:Stream Name="stdout" CommandId="c2">b3V0Mw==</rsp:Stream>
+      <rsp:CommandState CommandId="c2" State="Running"><rsp:ExitCode>1</rsp:ExitCode></rsp:CommandState>
+    </rsp:ReceiveResponse>
+  </s:Body>
+</s:Envelope>`
+
+func TestXMLQuerySelect(t *testing.T) {
+	root, err := decodeXML(testXMLQueryDocument)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"//Header/Action", []string{"http://schemas.microsoft.com/wbem/wsman/1/windows/shell/ReceiveResponse"}},
+		{"Action", []string{"http://schemas.microsoft.com/wbem/wsman/1/windows/shell/ReceiveResponse"}},
+		{"/Envelope/Header/a:MessageID", []string{"uuid:1"}},
+		{"/Header/Action", nil},
+		{"//Stream/@CommandId", []string{"c1", "c1", "c1", "c2"}},
+		{"//Stream[@Name='stdout']", []string{"b3V0MQ==", "b3V0Mg==", "b3V0Mw=="}},
+		{`//Stream[@Name="stderr"]/text()`, []string{"ZXJy"}},
+		{"//Stream[@Name]/@Name", []string{"stdout", "stdout", "stderr", "stdout"}},
+		{"//Stream[text()='ZXJy']/@Name", []string{"stderr"}},
+		{"//CommandState[ExitCode='1']/@State", []string{"Running"}},
+		{"//CommandState[ExitCode]/@CommandId", []string{"c1", "c2"}},
+		{"//*[@State='Done']/ExitCode", []string{"0"}},
+		{"//ReceiveResponse/*[1]/@CommandId", []string{"c1", "c2"}},
+		// [n] is applied per parent as in XPath, not to all matches
+		{"//Stream[1]", []string{"b3V0MQ==", "b3V0Mw=="}},
+		{"//Stream[2]", []string{"b3V0Mg=="}},
+		{"//Stream[3]/@Name", []string{"stderr"}},
+		{"//Stream[4]", nil},
+		{"//Stream[@Name='stdout'][2]", []string{"b3V0Mg=="}},
+		{"//ReceiveResponse[2]/Stream", []string{"b3V0Mw=="}},
+		{"//Body//ExitCode", []string{"0", "1"}},
+		{"//Missing", nil},
+	}
+	for _, tt := range tests {
+		q, err := compileXMLQuery(tt.path)
+		if err != nil {
+			t.Errorf("%s: compile error %s", tt.path, err)
+			continue
+		}
+		if got := q.Select(root); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCompileXMLQueryErrors(t *testing.T) {
+	for _, path := range []string{
+		"",
+		"//",
+		"//a//",
+		"//@Name",
+		"//a/@Name/b",
+		"//a/text()/b",
+		"//a[0]",
+		"//a[",
+		"//a[@b='x]",
+		"//a[@b=x]",
+		"//a[]",
+		"//a[1]x",
+	} {
+		if _, err := compileXMLQuery(path); err == nil {
+			t.Errorf("%q: error expected", path)
+		}
+	}
+}
+
+func TestCompileXMLQueryQuotedSeparators(t *testing.T) {
+	q, err := compileXMLQuery(`//a[@href='http://x/y[1]']/@id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := decodeXML(`<r><a href="http://x/y[1]" id="1"/><a href="z" id="2"/></r>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := q.Select(root); !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestParseXMLExtract(t *testing.T) {
+	e, err := parseXMLExtract("action=//Header/Action")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.name != "action" || e.query.source != "//Header/Action" {
+		t.Errorf("got %s=%s", e.name, e.query.source)
+	}
+	for _, s := range []string{"//Header/Action", "=//Action", "action=//"} {
+		if _, err := parseXMLExtract(s); err == nil {
+			t.Errorf("%q: error expected", s)
+		}
+	}
+}
+
+func TestExtractXMLFieldsOrder(t *testing.T) {
+	var extracts []*xmlExtract
+	for _, s := range []string{"action=//Action", "stream=//Stream/@Name", "body=//Body/text()"} {
+		e, err := parseXMLExtract(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		extracts = append(extracts, e)
+	}
+	bodyFields := map[string]struct{}{"a_body": {}, "b_body": {}, "c_body": {}, "d_body": {}}
+	var first []recordField
+	for i := 0; i < 20; i++ {
+		record, err := parseRecord([]byte(`{"msg":"x","d_body":"<Body>d</Body>","b_body":"<r><Action>b</Action><Stream Name='1'/><Stream Name='2'/></r>","c_body":"not xml","a_body":"<Action>a</Action>"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		extractXMLFields(record, bodyFields, extracts)
+		fields := record.fields[5:]
+		if i == 0 {
+			first = fields
+			want := []recordField{
+				{"action", "b"},
+				{"stream", []interface{}{"1", "2"}},
+				{"body", "d"},
+			}
+			if !reflect.DeepEqual(fields, want) {
+				t.Fatalf("got %#v, want %#v", fields, want)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(fields, first) {
+			t.Fatalf("run %d: got %#v, first run %#v", i, fields, first)
+		}
+	}
+}
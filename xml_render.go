@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type xmlViewMode int
+
+const (
+	xmlViewPretty xmlViewMode = iota
+	xmlViewCompact
+	xmlViewBoth
+)
+
+// xmlRenderOptions is used by xml body decoder, set by -xmlview and -xmldepth flags
+var xmlRenderOptions = struct {
+	mode     xmlViewMode
+	maxDepth int
+}{}
+
+// rawXMLNode is an element with names and attributes as written in source document
+type rawXMLNode struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	children []*rawXMLNode
+	text     strings.Builder
+	comments []string
+}
+
+func parseXMLViewMode(mode string) (xmlViewMode, error) {
+	switch mode {
+	case "", "pretty":
+		return xmlViewPretty, nil
+	case "compact":
+		return xmlViewCompact, nil
+	case "both":
+		return xmlViewBoth, nil
+	default:
+		return xmlViewPretty, errors.Errorf("Unknown xml view mode %s", mode)
+	}
+}
+
+// decodeRawXML builds tree without namespace resolution, keeping original prefixes
+func decodeRawXML(data string) (*rawXMLNode, []string, error) {
+	d := xml.NewDecoder(strings.NewReader(data))
+	root := &rawXMLNode{}
+	stack := []*rawXMLNode{root}
+	var prolog []string
+	for {
+		t, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		current := stack[len(stack)-1]
+		switch t := t.(type) {
+		case xml.StartElement:
+			n := &rawXMLNode{name: t.Name, attrs: t.Attr}
+			current.children = append(current.children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) == 1 {
+				return nil, nil, errors.Errorf("unexpected end element %s", rawXMLName(t.Name))
+			}
+			if t.Name != current.name {
+				return nil, nil, errors.Errorf("element <%s> closed by </%s>", rawXMLName(current.name), rawXMLName(t.Name))
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			current.text.Write(t)
+		case xml.Comment:
+			current.comments = append(current.comments, string(t))
+		case xml.ProcInst:
+			if len(stack) == 1 {
+				prolog = append(prolog, "<?"+t.Target+" "+string(t.Inst)+"?>")
+			}
+		}
+	}
+	if len(stack) != 1 {
+		return nil, nil, errors.Errorf("unclosed element %s", rawXMLName(stack[len(stack)-1].name))
+	}
+	if len(root.children) == 0 {
+		return nil, nil, errors.Errorf("not found root element")
+	}
+	return root.children[0], prolog, nil
+}
+
+func rawXMLName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+func escapeXMLText(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// renderXML renders body with selected view mode and depth limit
+func renderXML(data string, mode xmlViewMode, maxDepth int) (string, error) {
+	root, prolog, err := decodeRawXML(data)
+	if err != nil {
+		return "", err
+	}
+	return renderRawXML(root, prolog, mode, maxDepth), nil
+}
+
+// renderRawXML renders already decoded tree
+func renderRawXML(root *rawXMLNode, prolog []string, mode xmlViewMode, maxDepth int) string {
+	var b strings.Builder
+	if mode == xmlViewPretty || mode == xmlViewBoth {
+		for _, p := range prolog {
+			b.WriteString(p)
+			b.WriteString("\n")
+		}
+		root.renderPretty(&b, 0, maxDepth)
+	}
+	if mode == xmlViewBoth {
+		b.WriteString("\n")
+	}
+	if mode == xmlViewCompact || mode == xmlViewBoth {
+		root.renderCompact(&b, "", 0, maxDepth)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// renderPretty writes indented element, inlining text-only elements
+func (n *rawXMLNode) renderPretty(b *strings.Builder, depth, maxDepth int) {
+	indent := strings.Repeat("  ", depth)
+	name := rawXMLName(n.name)
+	b.WriteString(indent)
+	b.WriteString("<")
+	b.WriteString(name)
+	for _, attr := range n.attrs {
+		b.WriteString(" ")
+		b.WriteString(rawXMLName(attr.Name))
+		b.WriteString("=\"")
+		b.WriteString(escapeXMLText(attr.Value))
+		b.WriteString("\"")
+	}
+	text := strings.TrimSpace(n.text.String())
+	switch {
+	case len(n.children) == 0 && text == "":
+		b.WriteString("/>\n")
+		return
+	case len(n.children) == 0:
+		b.WriteString(">")
+		b.WriteString(escapeXMLText(text))
+	case maxDepth > 0 && depth+1 >= maxDepth:
+		b.WriteString(">...")
+	default:
+		b.WriteString(">\n")
+		for _, comment := range n.comments {
+			b.WriteString(indent + "  <!--" + comment + "-->\n")
+		}
+		if text != "" {
+			b.WriteString(indent + "  " + escapeXMLText(text) + "\n")
+		}
+		for _, child := range n.children {
+			child.renderPretty(b, depth+1, maxDepth)
+		}
+		b.WriteString(indent)
+	}
+	b.WriteString("</")
+	b.WriteString(name)
+	b.WriteString(">\n")
+}
+
+// renderCompact writes `Envelope/Header/Action = value` lines for attributes and text
+func (n *rawXMLNode) renderCompact(b *strings.Builder, parent string, depth, maxDepth int) {
+	path := n.name.Local
+	if parent != "" {
+		path = parent + "/" + path
+	}
+	for _, attr := range n.attrs {
+		if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+			continue
+		}
+		b.WriteString(path + "/@" + attr.Name.Local + " = " + attr.Value + "\n")
+	}
+	text := strings.TrimSpace(n.text.String())
+	if text != "" {
+		b.WriteString(path + " = " + text + "\n")
+	}
+	if len(n.children) == 0 {
+		if text == "" && len(n.attrs) == 0 {
+			b.WriteString(path + "\n")
+		}
+		return
+	}
+	if maxDepth > 0 && depth+1 >= maxDepth {
+		b.WriteString(path + "/...\n")
+		return
+	}
+	for _, child := range n.children {
+		child.renderCompact(b, path, depth+1, maxDepth)
+	}
+}